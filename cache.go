@@ -0,0 +1,98 @@
+package main
+
+import (
+	"container/list"
+	"math"
+	"sync"
+)
+
+// cacheKey is a query point rounded to 6 decimal places (about 0.1 m).
+type cacheKey struct {
+	lat, lng int64
+}
+
+func newCacheKey(lng float64, lat float64) cacheKey {
+	return cacheKey{
+		lat: int64(math.Round(lat * 1e6)),
+		lng: int64(math.Round(lng * 1e6)),
+	}
+}
+
+type cacheEntry struct {
+	key cacheKey
+	// set is the snapshot the lookup ran against; entries from an older
+	// snapshot are treated as misses after a reload.
+	set     *areaSet
+	feature *area
+}
+
+// lruCache remembers the area resolved for recently queried points. A nil
+// *lruCache is a valid, always-empty cache.
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[cacheKey]*list.Element
+	order    *list.List
+}
+
+func newLRUCache(capacity int) *lruCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &lruCache{
+		capacity: capacity,
+		items:    make(map[cacheKey]*list.Element, capacity),
+		order:    list.New(),
+	}
+}
+
+func (c *lruCache) get(key cacheKey, set *areaSet) (*area, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if entry.set != set {
+		c.order.Remove(element)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.feature, true
+}
+
+func (c *lruCache) put(key cacheKey, set *areaSet, feature *area) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		element.Value = &cacheEntry{key: key, set: set, feature: feature}
+		c.order.MoveToFront(element)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, set: set, feature: feature})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *lruCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = make(map[cacheKey]*list.Element, c.capacity)
+	c.order.Init()
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Config holds every setting that can be given on the command line, for
+// deployments that keep them in a -config file instead. Each field's JSON
+// name is the name of its flag; omitted fields keep the flag's value.
+type Config struct {
+	HTTPAddr          StringList `json:"http-addr,omitempty"`
+	HTTPSAddr         *string    `json:"https-addr,omitempty"`
+	Cert              *string    `json:"cert,omitempty"`
+	Key               *string    `json:"key,omitempty"`
+	AutocertDomain    StringList `json:"autocert-domain,omitempty"`
+	AutocertCache     *string    `json:"autocert-cache,omitempty"`
+	BatchLimit        *int       `json:"batch-limit,omitempty"`
+	BatchWorkers      *int       `json:"batch-workers,omitempty"`
+	MaxBodyBytes      *int64     `json:"max-body-bytes,omitempty"`
+	BoundaryEpsilon   *float64   `json:"boundary-epsilon,omitempty"`
+	PIPAlgorithm      *string    `json:"pip-algorithm,omitempty"`
+	SimplifyTolerance *float64   `json:"simplify-tolerance,omitempty"`
+	FallbackName      *string    `json:"fallback-name,omitempty"`
+	Strict            *bool      `json:"strict,omitempty"`
+	FallbackTypes     StringList `json:"fallback-types,omitempty"`
+	AllowedOrigins    StringList `json:"allowed-origins,omitempty"`
+	ReadTimeout       *Duration  `json:"read-timeout,omitempty"`
+	WriteTimeout      *Duration  `json:"write-timeout,omitempty"`
+	IdleTimeout       *Duration  `json:"idle-timeout,omitempty"`
+	LookupTimeout     *Duration  `json:"lookup-timeout,omitempty"`
+	GeocodeMaxAge     *Duration  `json:"geocode-max-age,omitempty"`
+	ShutdownTimeout   *Duration  `json:"shutdown-timeout,omitempty"`
+	CacheSize         *int       `json:"cache-size,omitempty"`
+	LogLevel          *string    `json:"log-level,omitempty"`
+	RateLimit         *float64   `json:"rate-limit,omitempty"`
+	RateBurst         *int       `json:"rate-burst,omitempty"`
+	TrustForwardedFor *bool      `json:"trust-forwarded-for,omitempty"`
+	ReadOnly          *bool      `json:"readonly,omitempty"`
+	Watch             *bool      `json:"watch,omitempty"`
+	Areas             *string    `json:"areas,omitempty"`
+
+	// Regions maps region names to areas paths, as -regions does.
+	Regions map[string]string `json:"regions,omitempty"`
+}
+
+// Duration is a time.Duration written in a config file as a string such as
+// "10s" or "2m30s".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"10s\"")
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+// StringList is a list setting, written in a config file as an array of
+// strings or, as http-addr was before it took several listeners, as a single
+// string.
+type StringList []string
+
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = StringList{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("list must be a string or an array of strings")
+	}
+	*l = list
+	return nil
+}
+
+// loadConfig reads the config file at path, rejecting unknown settings so
+// that a misspelled name is not silently ignored.
+func loadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("reading config file %q: %w", path, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var config Config
+	if err := decoder.Decode(&config); err != nil {
+		return Config{}, fmt.Errorf("parsing config file %q: %w", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid config file %q: %w", path, err)
+	}
+	return config, nil
+}
+
+// validate checks the settings that no flag parser would reject but that
+// make no sense.
+func (c Config) validate() error {
+	var errs []error
+	for name, value := range map[string]*int{"batch-limit": c.BatchLimit, "batch-workers": c.BatchWorkers, "rate-burst": c.RateBurst} {
+		if value != nil && *value < 1 {
+			errs = append(errs, fmt.Errorf("%s must be at least 1, got %d", name, *value))
+		}
+	}
+	if c.CacheSize != nil && *c.CacheSize < 0 {
+		errs = append(errs, fmt.Errorf("cache-size must not be negative, got %d", *c.CacheSize))
+	}
+	if c.MaxBodyBytes != nil && *c.MaxBodyBytes < 1 {
+		errs = append(errs, fmt.Errorf("max-body-bytes must be at least 1, got %d", *c.MaxBodyBytes))
+	}
+	for name, value := range map[string]*float64{"boundary-epsilon": c.BoundaryEpsilon, "simplify-tolerance": c.SimplifyTolerance, "rate-limit": c.RateLimit} {
+		if value != nil && *value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative, got %v", name, *value))
+		}
+	}
+	for name, value := range map[string]*Duration{
+		"read-timeout": c.ReadTimeout, "write-timeout": c.WriteTimeout, "idle-timeout": c.IdleTimeout,
+		"lookup-timeout": c.LookupTimeout, "geocode-max-age": c.GeocodeMaxAge, "shutdown-timeout": c.ShutdownTimeout,
+	} {
+		if value != nil && value.Duration < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative, got %v", name, value.Duration))
+		}
+	}
+	if c.PIPAlgorithm != nil {
+		if _, err := parsePointInPolygon(*c.PIPAlgorithm); err != nil {
+			errs = append(errs, fmt.Errorf("pip-algorithm: %w", err))
+		}
+	}
+	for name, path := range c.Regions {
+		if strings.Contains(path, ",") {
+			errs = append(errs, fmt.Errorf("regions: path %q of region %q contains a comma", path, name))
+		}
+	}
+	if _, err := parseRegions(formatRegions(c.Regions)); err != nil {
+		errs = append(errs, fmt.Errorf("regions: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// apply sets each flag in flags to its value in c, except for the flags in
+// explicit, which were given on the command line and take precedence.
+func (c Config) apply(flags *flag.FlagSet, explicit map[string]bool) error {
+	value := reflect.ValueOf(c)
+	for i := 0; i < value.NumField(); i++ {
+		name, _, _ := strings.Cut(value.Type().Field(i).Tag.Get("json"), ",")
+		field := value.Field(i)
+		if field.IsNil() || explicit[name] {
+			continue
+		}
+		var s string
+		switch v := field.Interface().(type) {
+		case StringList:
+			s = strings.Join(v, ",")
+		case map[string]string:
+			s = formatRegions(v)
+		default:
+			s = fmt.Sprint(field.Elem().Interface())
+		}
+		if err := flags.Set(name, s); err != nil {
+			return fmt.Errorf("setting %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// effectiveSettings returns the name and final value of every flag, for
+// logging at startup.
+func effectiveSettings(flags *flag.FlagSet) []any {
+	var settings []any
+	flags.VisitAll(func(f *flag.Flag) {
+		settings = append(settings, f.Name, f.Value.String())
+	})
+	return settings
+}
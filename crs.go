@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// CRS is the legacy GeoJSON (2008) named coordinate reference system
+// member, as still written by many GIS exports.
+type CRS struct {
+	Type       string `json:"type"`
+	Properties struct {
+		Name string `json:"name"`
+	} `json:"properties"`
+}
+
+// webMercatorRadius is the sphere radius of EPSG:3857, in meters.
+const webMercatorRadius = 6378137.0
+
+// crsCode reduces the usual spellings of a CRS name, such as
+// "urn:ogc:def:crs:EPSG::3857" or "EPSG:3857", to "EPSG:3857".
+func crsCode(name string) string {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	switch name {
+	case "URN:OGC:DEF:CRS:OGC:1.3:CRS84", "URN:OGC:DEF:CRS:OGC::CRS84", "CRS84":
+		return "EPSG:4326"
+	}
+	if i := strings.LastIndex(name, ":"); i >= 0 && strings.Contains(name, "EPSG") {
+		return "EPSG:" + name[i+1:]
+	}
+	return name
+}
+
+// reprojectToWGS84 converts the features of a collection whose crs member
+// names Web Mercator to longitude and latitude, and drops the member. A
+// collection without a crs, or in WGS84 already, is left alone; any other
+// CRS is an error rather than a file that silently matches nothing.
+func reprojectToWGS84(featureCollection *FeatureCollection) error {
+	if featureCollection.CRS == nil {
+		return nil
+	}
+	switch code := crsCode(featureCollection.CRS.Properties.Name); code {
+	case "EPSG:4326":
+	case "EPSG:3857", "EPSG:900913", "EPSG:102100", "EPSG:102113":
+		for i := range featureCollection.Features {
+			reprojectGeometry(&featureCollection.Features[i].Geometry, webMercatorToWGS84)
+		}
+	default:
+		return fmt.Errorf("unsupported crs %q: only EPSG:4326 and EPSG:3857 are supported", featureCollection.CRS.Properties.Name)
+	}
+	featureCollection.CRS = nil
+	return nil
+}
+
+// reprojectGeometry transforms every position of g in place.
+func reprojectGeometry(g *Geometry, transform func(x, y float64) (lng, lat float64)) {
+	for _, polygon := range g.Polygons {
+		for _, ring := range polygon {
+			for _, position := range ring {
+				if len(position) >= 2 {
+					position[0], position[1] = transform(position[0], position[1])
+				}
+			}
+		}
+	}
+	if g.Point != nil {
+		g.Point.Lng, g.Point.Lat = transform(g.Point.Lng, g.Point.Lat)
+	}
+}
+
+// webMercatorToWGS84 inverts the spherical Mercator projection of EPSG:3857.
+func webMercatorToWGS84(x float64, y float64) (float64, float64) {
+	lng := x / webMercatorRadius * 180 / math.Pi
+	lat := (2*math.Atan(math.Exp(y/webMercatorRadius)) - math.Pi/2) * 180 / math.Pi
+	return lng, lat
+}
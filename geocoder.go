@@ -0,0 +1,596 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// area is a loaded feature together with data precomputed at load time.
+type area struct {
+	Feature
+	bounds bbox
+	center Point
+	// size is the planar area in square degrees, holes excluded. It is
+	// only used to rank overlapping areas.
+	size float64
+	// areaKm2 is the area in square kilometers reported to clients.
+	areaKm2 float64
+	// perimeterKm is the length in kilometers of all of the feature's
+	// rings, holes included.
+	perimeterKm float64
+
+	// outlines are the flattened polygons used for containment tests: the
+	// feature's polygons simplified by simplifyTolerance, or the polygons
+	// themselves when simplification is off. Everything else uses the full
+	// polygons.
+	outlines []flatPolygon
+
+	// wrapped is set when the feature crosses the antimeridian and its
+	// negative longitudes were shifted by +360 so that rings are contiguous.
+	wrapped bool
+}
+
+func newArea(feature Feature) area {
+	// Hand-edited files sometimes omit the closing position of a ring, or
+	// wind rings against the right-hand rule.
+	polygons := make([]Polygon, len(feature.Geometry.Polygons))
+	for i, polygon := range feature.Geometry.Polygons {
+		var flipped []int
+		polygons[i], flipped = normalizeWinding(closeRings(polygon))
+		if len(flipped) > 0 {
+			slog.Info("Reversed ring winding", "id", feature.Properties.Id, "name", feature.Properties.Name, "polygon", i, "rings", flipped)
+		}
+	}
+	feature.Geometry.Polygons = polygons
+
+	wrapped := false
+	for _, polygon := range feature.Geometry.Polygons {
+		if len(polygon) > 0 && crossesAntimeridian(polygon[0]) {
+			wrapped = true
+			break
+		}
+	}
+	if wrapped {
+		polygons = make([]Polygon, len(feature.Geometry.Polygons))
+		for i, polygon := range feature.Geometry.Polygons {
+			polygons[i] = shiftLongitudes(polygon)
+		}
+		feature.Geometry.Polygons = polygons
+	}
+	outlines := make([]flatPolygon, len(feature.Geometry.Polygons))
+	for i, polygon := range feature.Geometry.Polygons {
+		outlines[i] = newFlatPolygon(simplifyPolygon(polygon, simplifyTolerance))
+	}
+	return area{
+		Feature:     feature,
+		outlines:    outlines,
+		bounds:      featureBounds(feature),
+		center:      featureCentroid(feature),
+		size:        featureSize(feature),
+		areaKm2:     featureAreaKm2(feature),
+		perimeterKm: featurePerimeterKm(feature),
+		wrapped:     wrapped,
+	}
+}
+
+// contains reports whether any of the area's outlines contains the point.
+func (a *area) contains(lng float64, lat float64) bool {
+	return a.locate(lng, lat) >= 0
+}
+
+// locate returns the index of the first polygon containing the point, or -1.
+func (a *area) locate(lng float64, lat float64) int {
+	return a.locateWith(pointInPolygon, lng, lat)
+}
+
+// locateWith is locate using the given point-in-polygon algorithm rather
+// than the configured one.
+func (a *area) locateWith(algorithm PointInPolygon, lng float64, lat float64) int {
+	for i, outline := range a.outlines {
+		if algorithm.Contains(a.queryLng(lng), lat, outline) {
+			return i
+		}
+	}
+	return -1
+}
+
+// classify reports whether the point is inside, on the boundary of or
+// outside the area, using classifyPolygon on each of its polygons.
+func (a *area) classify(lng float64, lat float64) int {
+	class := pointOutside
+	for _, polygon := range a.Geometry.Polygons {
+		if c := classifyPolygon(a.queryLng(lng), lat, polygon); c > class {
+			class = c
+		}
+	}
+	return class
+}
+
+// queryLng applies the same longitude shift to a query point that was
+// applied to the area's rings at load time.
+func (a *area) queryLng(lng float64) float64 {
+	if a.wrapped && lng < 0 {
+		return lng + 360
+	}
+	return lng
+}
+
+// areaSet is an immutable snapshot of the loaded areas.
+type areaSet struct {
+	collection FeatureCollection
+	// etag identifies the serialized collection, so that it changes
+	// exactly when a reload changes the data.
+	etag  string
+	areas []area
+	index *SpatialIndex
+	// pois are the features with Point geometries. They are only used by
+	// nearestPOI; every other lookup considers the polygon areas alone.
+	pois []Feature
+	// propertyKeys holds every property name set on some feature, so that
+	// filters on a property no feature has can be rejected.
+	propertyKeys map[string]bool
+}
+
+func newAreaSet(featureCollection FeatureCollection) *areaSet {
+	set := &areaSet{
+		collection:   featureCollection,
+		etag:         collectionETag(featureCollection),
+		areas:        make([]area, 0, len(featureCollection.Features)),
+		index:        NewSpatialIndex(gridCellSize),
+		propertyKeys: map[string]bool{"name": true, "id": true},
+	}
+	for _, feature := range featureCollection.Features {
+		if feature.Geometry.Point != nil {
+			set.pois = append(set.pois, feature)
+			continue
+		}
+		set.areas = append(set.areas, newArea(feature))
+		for key := range feature.Properties.Extra {
+			set.propertyKeys[key] = true
+		}
+	}
+	// Index only once areas is complete, as appending may move it.
+	for i := range set.areas {
+		set.index.insert(&set.areas[i])
+	}
+	return set
+}
+
+// collectionETag returns a strong ETag for the JSON encoding of
+// featureCollection. An Encoder buffers each value it encodes in full, so
+// the features are encoded into the hash one at a time rather than as one
+// collection, which can be as large as the areas file.
+func collectionETag(featureCollection FeatureCollection) string {
+	hash := sha256.New()
+	encoder := json.NewEncoder(hash)
+	if err := encoder.Encode(featureCollection.Type); err != nil {
+		return ""
+	}
+	for _, feature := range featureCollection.Features {
+		if err := encoder.Encode(feature); err != nil {
+			// Every loaded feature was decoded from JSON, so this does not
+			// happen; an empty ETag just disables conditional requests.
+			slog.Error("Error marshalling areas for ETag", "id", feature.Properties.Id, "err", err)
+			return ""
+		}
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// candidates returns the areas whose bounding box contains the point. A nil
+// set has no candidates.
+func (s *areaSet) candidates(lng float64, lat float64) []*area {
+	if s == nil {
+		return nil
+	}
+	candidates := s.index.candidates(lng, lat)
+	if lng < 0 {
+		// Areas that cross the antimeridian are indexed in shifted longitudes.
+		candidates = append(candidates, s.index.candidates(lng+360, lat)...)
+	}
+	return candidates
+}
+
+// simplifyTolerance is the Douglas-Peucker tolerance, in degrees, applied to
+// polygons for containment tests when areas are loaded. 0 disables it.
+var simplifyTolerance float64
+
+// defaultCacheSize is the number of lookups a new Geocoder caches.
+const defaultCacheSize = 4096
+
+// ErrNoData is returned when a Geocoder has no areas loaded.
+var ErrNoData = errors.New("no area data loaded")
+
+// Geocoder resolves points to the areas of a feature collection. It is safe
+// for concurrent use; Reload swaps in a fresh snapshot without disturbing
+// lookups that are already running. The zero Geocoder has no data: Available
+// reports false until areas are loaded, and lookups never match.
+type Geocoder struct {
+	// path is the file the areas were loaded from, if any.
+	path string
+
+	mu  sync.RWMutex
+	set *areaSet // nil until areas are loaded
+
+	// cache holds recent findFeature results. It is nil when disabled.
+	cache *lruCache
+}
+
+// NewGeocoder returns a Geocoder over featureCollection.
+func NewGeocoder(featureCollection FeatureCollection) *Geocoder {
+	g := &Geocoder{cache: newLRUCache(defaultCacheSize)}
+	g.setAreas(featureCollection)
+	return g
+}
+
+// NewGeocoderFromFile loads path and returns a Geocoder over its features.
+func NewGeocoderFromFile(path string) (*Geocoder, error) {
+	featureCollection, err := loadAreas(path)
+	if err != nil {
+		return nil, err
+	}
+	g := NewGeocoder(featureCollection)
+	g.path = path
+	return g, nil
+}
+
+// SetCacheSize replaces the lookup cache with one holding up to size
+// entries. A size of 0 disables caching.
+func (g *Geocoder) SetCacheSize(size int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.cache = newLRUCache(size)
+}
+
+// Reload re-reads the file the Geocoder was loaded from. On error the
+// current areas are kept. It returns the number of features loaded.
+func (g *Geocoder) Reload() (int, error) {
+	featureCollection, err := loadAreas(g.path)
+	if err != nil {
+		return 0, err
+	}
+	g.setAreas(featureCollection)
+	return len(featureCollection.Features), nil
+}
+
+// Available reports whether the Geocoder has areas loaded. Handlers use it
+// to tell "no data" apart from "no match".
+func (g *Geocoder) Available() bool {
+	return g.currentAreas() != nil
+}
+
+func (g *Geocoder) currentAreas() *areaSet {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.set
+}
+
+func (g *Geocoder) setAreas(featureCollection FeatureCollection) {
+	set := newAreaSet(featureCollection)
+	if len(set.areas) == 0 {
+		slog.Warn("No usable features in areas; every lookup will return the fallback")
+	}
+	g.mu.Lock()
+	g.set = set
+	cache := g.cache
+	g.mu.Unlock()
+	cache.clear()
+}
+
+// Lookup returns the name and id of the area containing the point. ok is
+// false when no area contains it. It returns ErrNoData if no areas are loaded.
+func (g *Geocoder) Lookup(lng float64, lat float64) (name string, id string, ok bool, err error) {
+	if !g.Available() {
+		return "", "", false, ErrNoData
+	}
+	if feature := g.findFeature(lng, lat); feature != nil {
+		return feature.Properties.Name, feature.Properties.Id, true, nil
+	}
+	return "", "", false, nil
+}
+
+// findFeature returns the smallest area containing the point, or nil. When
+// zones overlap this is the most specific one; equal sizes keep file order.
+func (g *Geocoder) findFeature(lng float64, lat float64) *area {
+	feature, _ := g.findFeatureContext(context.Background(), pointInPolygon, lng, lat)
+	return feature
+}
+
+// findFeatureContext is findFeature using the given point-in-polygon
+// algorithm, giving up with ctx's error once ctx is done. Only lookups with
+// the configured algorithm go through the cache.
+func (g *Geocoder) findFeatureContext(ctx context.Context, algorithm PointInPolygon, lng float64, lat float64) (*area, error) {
+	g.mu.RLock()
+	set, cache := g.set, g.cache
+	g.mu.RUnlock()
+
+	if algorithm != pointInPolygon {
+		return lookupFeatureContext(ctx, set, algorithm, lng, lat)
+	}
+
+	key := newCacheKey(lng, lat)
+	if feature, ok := cache.get(key, set); ok {
+		cacheLookups.WithLabelValues("hit").Inc()
+		return feature, nil
+	}
+	cacheLookups.WithLabelValues("miss").Inc()
+
+	feature, err := lookupFeatureContext(ctx, set, algorithm, lng, lat)
+	if err != nil {
+		return nil, err
+	}
+	cache.put(key, set, feature)
+	return feature, nil
+}
+
+func lookupFeature(set *areaSet, lng float64, lat float64) *area {
+	feature, _ := lookupFeatureContext(context.Background(), set, pointInPolygon, lng, lat)
+	return feature
+}
+
+// lookupFeatureContext is lookupFeature using the given point-in-polygon
+// algorithm. It checks ctx before testing each candidate, so that a lookup
+// over a huge unindexed dataset can be abandoned.
+func lookupFeatureContext(ctx context.Context, set *areaSet, algorithm PointInPolygon, lng float64, lat float64) (*area, error) {
+	if set == nil {
+		return nil, nil
+	}
+	candidates := set.candidates(lng, lat)
+	slog.Debug("findArea", "features", len(set.areas), "candidates", len(candidates))
+	var match *area
+	for _, feature := range candidates {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if (match == nil || feature.size < match.size) && feature.locateWith(algorithm, lng, lat) >= 0 {
+			match = feature
+		}
+	}
+
+	return match, nil
+}
+
+// batchWorkers is the number of goroutines findFeatures spreads a batch
+// over.
+var batchWorkers = runtime.GOMAXPROCS(0)
+
+// minPointsPerWorker keeps findFeatures from starting goroutines for batches
+// too small to benefit.
+const minPointsPerWorker = 64
+
+// findFeatures looks up every point with findFeature, spread over up to
+// batchWorkers goroutines, and returns the matches in the order of points.
+// It stops with ctx's error once ctx is done.
+func (g *Geocoder) findFeatures(ctx context.Context, points []Point) ([]*area, error) {
+	features := make([]*area, len(points))
+	workers := batchWorkers
+	if limit := len(points) / minPointsPerWorker; workers > limit {
+		workers = limit
+	}
+	lookup := func(start int, end int) error {
+		for i := start; i < end; i++ {
+			feature, err := g.findFeatureContext(ctx, pointInPolygon, points[i].Lng, points[i].Lat)
+			if err != nil {
+				return err
+			}
+			features[i] = feature
+		}
+		return nil
+	}
+	if workers <= 1 {
+		return features, lookup(0, len(points))
+	}
+
+	var wg sync.WaitGroup
+	chunk := (len(points) + workers - 1) / workers
+	errs := make([]error, 0, workers)
+	var mu sync.Mutex
+	for start := 0; start < len(points); start += chunk {
+		end := min(start+chunk, len(points))
+		wg.Add(1)
+		go func(start int, end int) {
+			defer wg.Done()
+			if err := lookup(start, end); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return features, nil
+}
+
+// findAreas returns every feature containing the point, ordered from the
+// smallest area to the largest.
+func (g *Geocoder) findAreas(lng float64, lat float64) []Feature {
+	matches := g.findAllFeatures(lng, lat)
+	features := make([]Feature, len(matches))
+	for i, feature := range matches {
+		features[i] = feature.Feature
+	}
+	return features
+}
+
+func (g *Geocoder) findAllFeatures(lng float64, lat float64) []*area {
+	matches, _ := g.findAllFeaturesContext(context.Background(), pointInPolygon, lng, lat)
+	return matches
+}
+
+// findAllFeaturesContext is findAllFeatures using the given point-in-polygon
+// algorithm, giving up with ctx's error once ctx is done.
+func (g *Geocoder) findAllFeaturesContext(ctx context.Context, algorithm PointInPolygon, lng float64, lat float64) ([]*area, error) {
+	var matches []*area
+	for _, feature := range g.currentAreas().candidates(lng, lat) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if feature.locateWith(algorithm, lng, lat) >= 0 {
+			matches = append(matches, feature)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].size < matches[j].size
+	})
+	return matches, nil
+}
+
+// findNearestArea returns the feature whose boundary is closest to the point,
+// along with that distance in meters. It is meant for points that fall
+// outside every polygon.
+func (g *Geocoder) findNearestArea(lng float64, lat float64) (string, string, float64) {
+	feature, distance := g.findNearestFeature(lng, lat)
+	if feature == nil {
+		return "", "", 0
+	}
+	return feature.Properties.Name, feature.Properties.Id, distance
+}
+
+func (g *Geocoder) findNearestFeature(lng float64, lat float64) (*area, float64) {
+	return g.findNearestFeatureWhere(lng, lat, nil)
+}
+
+// findNearestFeatureWhere is findNearestFeature over only the features keep
+// reports true for. A nil keep considers every feature.
+func (g *Geocoder) findNearestFeatureWhere(lng float64, lat float64, keep func(*area) bool) (*area, float64) {
+	var nearestFeature *area
+	nearest := math.Inf(1)
+	set := g.currentAreas()
+	if set == nil {
+		return nil, 0
+	}
+	areas := set.areas
+	for i := range areas {
+		if keep != nil && !keep(&areas[i]) {
+			continue
+		}
+		if d := featureDistance(&areas[i], lng, lat); d < nearest {
+			nearestFeature, nearest = &areas[i], d
+		}
+	}
+	return nearestFeature, nearest
+}
+
+// nearbyArea is a feature and the distance in meters from a query point to
+// its boundary.
+type nearbyArea struct {
+	feature  *area
+	distance float64
+}
+
+// farthestFirst is a max-heap of nearbyAreas by distance, so that the k
+// nearest features seen so far can be kept while evicting the farthest.
+type farthestFirst []nearbyArea
+
+func (h farthestFirst) Len() int           { return len(h) }
+func (h farthestFirst) Less(i, j int) bool { return h[i].distance > h[j].distance }
+func (h farthestFirst) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *farthestFirst) Push(x any)        { *h = append(*h, x.(nearbyArea)) }
+func (h *farthestFirst) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// findNearestFeatures returns the k features whose boundaries are closest to
+// the point, nearest first. Only k candidates are held at a time, so large
+// collections cost O(n log k) rather than a full sort.
+func (g *Geocoder) findNearestFeatures(lng float64, lat float64, k int) []nearbyArea {
+	set := g.currentAreas()
+	if set == nil || k < 1 {
+		return nil
+	}
+	nearest := make(farthestFirst, 0, k)
+	for i := range set.areas {
+		d := featureDistance(&set.areas[i], lng, lat)
+		if len(nearest) < k {
+			heap.Push(&nearest, nearbyArea{feature: &set.areas[i], distance: d})
+		} else if d < nearest[0].distance {
+			nearest[0] = nearbyArea{feature: &set.areas[i], distance: d}
+			heap.Fix(&nearest, 0)
+		}
+	}
+	sorted := make([]nearbyArea, len(nearest))
+	for i := len(sorted) - 1; i >= 0; i-- {
+		sorted[i] = heap.Pop(&nearest).(nearbyArea)
+	}
+	return sorted
+}
+
+// nearestVertex returns the polygon vertex closest to the point across all
+// areas, and the feature it belongs to. ok is false if no vertex lies within
+// radius meters.
+func (g *Geocoder) nearestVertex(lng float64, lat float64, radius float64) (Point, Feature, bool) {
+	set := g.currentAreas()
+	if set == nil {
+		return Point{}, Feature{}, false
+	}
+
+	var vertex Point
+	var feature *area
+	nearest := radius
+	for i := range set.areas {
+		a := &set.areas[i]
+		for _, polygon := range a.Geometry.Polygons {
+			for _, ring := range polygon {
+				for _, point := range ring {
+					vertexLng := wrapLng(point[0])
+					if d := haversineMeters(lng, lat, vertexLng, point[1]); d <= nearest {
+						vertex, feature, nearest = Point{Lng: vertexLng, Lat: point[1]}, a, d
+					}
+				}
+			}
+		}
+	}
+	if feature == nil {
+		return Point{}, Feature{}, false
+	}
+	return vertex, feature.Feature, true
+}
+
+// nearestPOI returns the point of interest closest to the point and its
+// distance in meters. ok is false if none lies within radius meters.
+func (g *Geocoder) nearestPOI(lng float64, lat float64, radius float64) (Feature, float64, bool) {
+	set := g.currentAreas()
+	if set == nil {
+		return Feature{}, 0, false
+	}
+
+	var poi *Feature
+	nearest := radius
+	for i := range set.pois {
+		point := set.pois[i].Geometry.Point
+		if d := haversineMeters(lng, lat, point.Lng, point.Lat); d <= nearest {
+			poi, nearest = &set.pois[i], d
+		}
+	}
+	if poi == nil {
+		return Feature{}, 0, false
+	}
+	return *poi, nearest, true
+}
+
+// featureDistance returns the distance in meters from the point to the
+// closest ring, outer or hole, of any of the feature's polygons.
+func featureDistance(feature *area, lng float64, lat float64) float64 {
+	nearest := math.Inf(1)
+	for _, polygon := range feature.Geometry.Polygons {
+		for _, ring := range polygon {
+			nearest = math.Min(nearest, distanceToPolygon(feature.queryLng(lng), lat, ring))
+		}
+	}
+	return nearest
+}
@@ -0,0 +1,51 @@
+package main
+
+// defaultGeohashPrecision is the geohash length used when a request does not
+// ask for one; 9 characters is a cell of roughly 5 m by 5 m.
+const defaultGeohashPrecision = 9
+
+// maxGeohashPrecision is the longest geohash geohashEncode produces. Twelve
+// characters already resolve a few centimeters.
+const maxGeohashPrecision = 12
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// geohashEncode returns the geohash of the point with precision characters.
+// Bits alternate between longitude and latitude, starting with longitude,
+// each halving the remaining range; every 5 bits select one base32 digit.
+func geohashEncode(lat float64, lng float64, precision int) string {
+	minLat, maxLat := -90.0, 90.0
+	minLng, maxLng := -180.0, 180.0
+
+	hash := make([]byte, 0, precision)
+	even := true
+	bits, digit := 0, 0
+	for len(hash) < precision {
+		if even {
+			mid := (minLng + maxLng) / 2
+			if lng >= mid {
+				digit = digit<<1 | 1
+				minLng = mid
+			} else {
+				digit <<= 1
+				maxLng = mid
+			}
+		} else {
+			mid := (minLat + maxLat) / 2
+			if lat >= mid {
+				digit = digit<<1 | 1
+				minLat = mid
+			} else {
+				digit <<= 1
+				maxLat = mid
+			}
+		}
+		even = !even
+
+		if bits++; bits == 5 {
+			hash = append(hash, geohashAlphabet[digit])
+			bits, digit = 0, 0
+		}
+	}
+	return string(hash)
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type Point struct {
+	Lng float64
+	Lat float64
+}
+
+// Polygon is a list of linear rings. The first ring is the outer boundary.
+type Polygon [][][]float64
+
+// Geometry is a GeoJSON Polygon, MultiPolygon or Point. Polygons holds the
+// coordinates decoded into one Polygon per part, and Point the position of a
+// Point, which has no Polygons; the raw coordinates are not kept, and
+// MarshalJSON encodes them again from these. A null geometry has an empty
+// Type, and other geometry types keep their Type but are left undecoded, so
+// that checkGeometry can skip just that feature. Coordinates that do not
+// decode are likewise not an error for the whole file: the error is kept in
+// invalid for checkGeometry to report.
+type Geometry struct {
+	Type string `json:"type"`
+
+	Polygons []Polygon `json:"-"`
+	Point    *Point    `json:"-"`
+
+	invalid error
+}
+
+// rawGeometry is the GeoJSON encoding of a Geometry.
+type rawGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+func (g *Geometry) UnmarshalJSON(data []byte) error {
+	if string(bytes.TrimSpace(data)) == "null" {
+		*g = Geometry{}
+		return nil
+	}
+	var raw rawGeometry
+	if err := json.Unmarshal(data, &raw); err != nil {
+		*g = Geometry{invalid: err}
+		return nil
+	}
+	*g = Geometry{Type: raw.Type}
+
+	switch g.Type {
+	case "Polygon":
+		var polygon Polygon
+		if err := json.Unmarshal(raw.Coordinates, &polygon); err != nil {
+			g.invalid = fmt.Errorf("decoding Polygon coordinates: %w", err)
+			return nil
+		}
+		g.Polygons = []Polygon{polygon}
+	case "MultiPolygon":
+		var polygons []Polygon
+		if err := json.Unmarshal(raw.Coordinates, &polygons); err != nil {
+			g.invalid = fmt.Errorf("decoding MultiPolygon coordinates: %w", err)
+			return nil
+		}
+		g.Polygons = polygons
+	case "Point":
+		var position []float64
+		if err := json.Unmarshal(raw.Coordinates, &position); err != nil {
+			g.invalid = fmt.Errorf("decoding Point coordinates: %w", err)
+			return nil
+		}
+		if len(position) < 2 {
+			g.invalid = fmt.Errorf("Point has %d values, need 2", len(position))
+			return nil
+		}
+		g.Point = &Point{Lng: position[0], Lat: position[1]}
+	}
+	return nil
+}
+
+func (g Geometry) MarshalJSON() ([]byte, error) {
+	if g.Type == "" {
+		return []byte("null"), nil
+	}
+	var coordinates any
+	switch {
+	case g.Point != nil:
+		coordinates = []float64{g.Point.Lng, g.Point.Lat}
+	case g.Type == "Polygon" && len(g.Polygons) == 1:
+		coordinates = g.Polygons[0]
+	case g.Polygons != nil:
+		coordinates = g.Polygons
+	}
+	raw, err := json.Marshal(coordinates)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(rawGeometry{Type: g.Type, Coordinates: raw})
+}
+
+// Properties are a feature's GeoJSON properties. Name and Id are the ones
+// geomocker uses; every other member is kept in Extra so it can be passed
+// through to clients.
+type Properties struct {
+	Name string `json:"name"`
+	Id   string `json:"id"`
+
+	Extra map[string]any `json:"-"`
+}
+
+func (p *Properties) UnmarshalJSON(data []byte) error {
+	type properties Properties
+	var known properties
+	if err := json.Unmarshal(data, &known); err != nil {
+		return err
+	}
+	var all map[string]any
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	delete(all, "name")
+	delete(all, "id")
+	if len(all) > 0 {
+		known.Extra = all
+	}
+	*p = Properties(known)
+	return nil
+}
+
+func (p Properties) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.all())
+}
+
+// all returns every property, Name and Id included, as one map.
+func (p Properties) all() map[string]any {
+	all := make(map[string]any, len(p.Extra)+2)
+	for key, value := range p.Extra {
+		all[key] = value
+	}
+	all["name"] = p.Name
+	all["id"] = p.Id
+	return all
+}
+
+// enabled reports whether the feature should be used for lookups. Features
+// are enabled unless their "enabled" property is false, so a zone can be
+// switched off by editing the file and reloading.
+func (p Properties) enabled() bool {
+	enabled, ok := p.Extra["enabled"].(bool)
+	return !ok || enabled
+}
+
+// matches reports whether the property key has the value want. Name and Id
+// are matched like any other property; values that are not strings, such
+// as numbers and booleans, are compared in their fmt.Sprint form.
+func (p Properties) matches(key string, want string) bool {
+	var value any
+	switch key {
+	case "name":
+		value = p.Name
+	case "id":
+		value = p.Id
+	default:
+		var ok bool
+		if value, ok = p.Extra[key]; !ok {
+			return false
+		}
+	}
+	if s, ok := value.(string); ok {
+		return s == want
+	}
+	return fmt.Sprint(value) == want
+}
+
+type Feature struct {
+	Properties Properties `json:"properties"`
+	Geometry   Geometry   `json:"geometry"`
+	Type       string     `json:"type"`
+}
+
+type FeatureCollection struct {
+	Features []Feature `json:"features"`
+	Type     string    `json:"type"`
+
+	// CRS is only set while loading; features are reprojected to WGS84 and
+	// it is cleared.
+	CRS *CRS `json:"crs,omitempty"`
+}
+
+// loadAreas reads the areas at path and drops the features that cannot be
+// used for lookups, or are disabled. Gzipped files are decompressed
+// transparently. If path is a directory, every *.json and *.geojson
+// FeatureCollection in it, gzipped or not, is merged in file name order, and
+// features that repeat an earlier properties.id are dropped.
+func loadAreas(path string) (FeatureCollection, error) {
+	featureCollection, err := readAreas(path)
+	if err != nil {
+		return featureCollection, err
+	}
+	featureCollection.Features = enabledFeatures(validFeatures(featureCollection.Features))
+	return featureCollection, nil
+}
+
+// readAreas is loadAreas without dropping malformed features.
+func readAreas(path string) (FeatureCollection, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return FeatureCollection{}, fmt.Errorf("reading areas file %q: %w", path, err)
+	}
+	if info.IsDir() {
+		return loadAreasDir(path)
+	}
+	return loadAreasFile(path)
+}
+
+// loadAreasFile decodes the FeatureCollection in path one feature at a time
+// straight from the file, so that peak memory stays close to a single copy
+// of the decoded features. Files in Web Mercator are reprojected to WGS84.
+func loadAreasFile(path string) (FeatureCollection, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FeatureCollection{}, fmt.Errorf("reading areas file %q: %w", path, err)
+	}
+	defer file.Close()
+
+	reader, err := gunzipIfCompressed(bufio.NewReader(file))
+	if err != nil {
+		return FeatureCollection{}, fmt.Errorf("decompressing areas file %q: %w", path, err)
+	}
+
+	featureCollection, err := decodeFeatureCollection(json.NewDecoder(reader))
+	if err != nil {
+		return featureCollection, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := reprojectToWGS84(&featureCollection); err != nil {
+		return featureCollection, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return featureCollection, nil
+}
+
+// decodeFeatureCollection reads a FeatureCollection object from decoder,
+// decoding the features array element by element. Unknown members are
+// skipped.
+func decodeFeatureCollection(decoder *json.Decoder) (FeatureCollection, error) {
+	var featureCollection FeatureCollection
+	if err := expectDelim(decoder, '{'); err != nil {
+		return featureCollection, err
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return featureCollection, err
+		}
+		switch key, _ := token.(string); key {
+		case "type":
+			err = decoder.Decode(&featureCollection.Type)
+		case "features":
+			featureCollection.Features, err = decodeFeatures(decoder)
+		case "crs":
+			err = decoder.Decode(&featureCollection.CRS)
+		default:
+			var skipped json.RawMessage
+			err = decoder.Decode(&skipped)
+		}
+		if err != nil {
+			return featureCollection, err
+		}
+	}
+	return featureCollection, expectDelim(decoder, '}')
+}
+
+func decodeFeatures(decoder *json.Decoder) ([]Feature, error) {
+	token, err := decoder.Token()
+	if err != nil || token == nil {
+		// "features": null decodes to no features, as json.Unmarshal would.
+		return nil, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return nil, fmt.Errorf("expected [, got %v", token)
+	}
+	var features []Feature
+	for decoder.More() {
+		var feature Feature
+		if err := decoder.Decode(&feature); err != nil {
+			return features, fmt.Errorf("feature %d: %w", len(features), err)
+		}
+		features = append(features, feature)
+	}
+	return features, expectDelim(decoder, ']')
+}
+
+// expectDelim reads the next token and fails unless it is delim.
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if got, ok := token.(json.Delim); !ok || got != delim {
+		return fmt.Errorf("expected %v, got %v", delim, token)
+	}
+	return nil
+}
+
+// gunzipIfCompressed wraps reader in a gzip reader if the stream starts with
+// the gzip magic bytes, whatever the file is called.
+func gunzipIfCompressed(reader *bufio.Reader) (io.Reader, error) {
+	magic, err := reader.Peek(2)
+	if err != nil || !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		// Plain JSON, or too short to be anything; the decoder reports it.
+		return reader, nil
+	}
+	return gzip.NewReader(reader)
+}
+
+// isAreasFile reports whether a file in an areas directory should be loaded:
+// *.json and *.geojson, optionally gzipped with a further .gz extension.
+func isAreasFile(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".gz")
+	ext := filepath.Ext(name)
+	return ext == ".json" || ext == ".geojson"
+}
+
+func loadAreasDir(dir string) (FeatureCollection, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return FeatureCollection{}, fmt.Errorf("reading areas directory %q: %w", dir, err)
+	}
+
+	merged := FeatureCollection{Type: "FeatureCollection"}
+	seen := map[string]string{}
+	files := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !isAreasFile(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		featureCollection, err := loadAreasFile(path)
+		if err != nil {
+			return FeatureCollection{}, err
+		}
+		files++
+
+		for _, feature := range featureCollection.Features {
+			id := feature.Properties.Id
+			if first, ok := seen[id]; ok && id != "" {
+				slog.Warn("Skipping duplicate feature", "id", id, "file", entry.Name(), "first", first)
+				continue
+			}
+			seen[id] = entry.Name()
+			merged.Features = append(merged.Features, feature)
+		}
+	}
+	if files == 0 {
+		return FeatureCollection{}, fmt.Errorf("no .json or .geojson files in %q", dir)
+	}
+
+	slog.Info("Loaded areas directory", "dir", dir, "files", files, "features", len(merged.Features))
+	return merged, nil
+}
+
+// validFeatures drops, with a warning, every feature that cannot be used for
+// point-in-polygon tests or, for Points, as a point of interest.
+func validFeatures(features []Feature) []Feature {
+	valid := make([]Feature, 0, len(features))
+	for i, feature := range features {
+		if err := checkGeometry(feature.Geometry); err != nil {
+			slog.Warn("Skipping malformed feature",
+				"index", i, "id", feature.Properties.Id, "name", feature.Properties.Name, "err", err)
+			continue
+		}
+		valid = append(valid, feature)
+	}
+	return valid
+}
+
+// enabledFeatures drops every feature whose "enabled" property is false.
+func enabledFeatures(features []Feature) []Feature {
+	enabled := make([]Feature, 0, len(features))
+	for _, feature := range features {
+		if !feature.Properties.enabled() {
+			slog.Info("Skipping disabled feature", "id", feature.Properties.Id, "name", feature.Properties.Name)
+			continue
+		}
+		enabled = append(enabled, feature)
+	}
+	return enabled
+}
+
+// checkGeometry reports the first empty or degenerate part of a geometry.
+func checkGeometry(geometry Geometry) error {
+	if geometry.invalid != nil {
+		return geometry.invalid
+	}
+	switch geometry.Type {
+	case "Polygon", "MultiPolygon", "Point":
+	case "":
+		return fmt.Errorf("no geometry")
+	default:
+		return fmt.Errorf("unsupported geometry type %q", geometry.Type)
+	}
+	if geometry.Point != nil {
+		return nil
+	}
+	if len(geometry.Polygons) == 0 {
+		return fmt.Errorf("no coordinates")
+	}
+	for p, polygon := range geometry.Polygons {
+		if len(polygon) == 0 {
+			return fmt.Errorf("polygon %d has no rings", p)
+		}
+		for r, ring := range polygon {
+			if len(ring) < 3 {
+				return fmt.Errorf("polygon %d ring %d has %d positions, need at least 3", p, r, len(ring))
+			}
+			for i, point := range ring {
+				if len(point) < 2 {
+					return fmt.Errorf("polygon %d ring %d position %d has %d values, need 2", p, r, i, len(point))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateAreas checks every feature more strictly than loadAreas does and
+// returns one message per problem found. Besides the geometry checks, each
+// feature needs a name, its enabled property if any must be a boolean, and
+// each ring must be closed, have at least four positions and stay within
+// valid longitude and latitude ranges.
+func validateAreas(features []Feature) []string {
+	var problems []string
+	for i, feature := range features {
+		report := func(format string, args ...any) {
+			label := fmt.Sprintf("feature %d (id %q)", i, feature.Properties.Id)
+			problems = append(problems, label+": "+fmt.Sprintf(format, args...))
+		}
+
+		if strings.TrimSpace(feature.Properties.Name) == "" {
+			report("missing name")
+		}
+		if value, ok := feature.Properties.Extra["enabled"]; ok {
+			if _, isBool := value.(bool); !isBool {
+				report("enabled is %v, want true or false", value)
+			}
+		}
+		if err := checkGeometry(feature.Geometry); err != nil {
+			report("%v", err)
+			continue
+		}
+		if point := feature.Geometry.Point; point != nil {
+			if point.Lng < -180 || point.Lng > 180 || point.Lat < -90 || point.Lat > 90 {
+				report("point [%v, %v] is out of range", point.Lng, point.Lat)
+			}
+			continue
+		}
+		for p, polygon := range feature.Geometry.Polygons {
+			for r, ring := range polygon {
+				if len(ring) < 4 {
+					report("polygon %d ring %d has %d positions, need at least 4", p, r, len(ring))
+				}
+				first, last := ring[0], ring[len(ring)-1]
+				if first[0] != last[0] || first[1] != last[1] {
+					report("polygon %d ring %d is not closed", p, r)
+				}
+				for j, point := range ring {
+					if lng, lat := point[0], point[1]; lng < -180 || lng > 180 || lat < -90 || lat > 90 {
+						report("polygon %d ring %d position %d [%v, %v] is out of range", p, r, j, lng, lat)
+						break
+					}
+				}
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,571 @@
+package main
+
+import "math"
+
+// bbox is an axis-aligned bounding box in degrees.
+type bbox struct {
+	MinLng, MinLat, MaxLng, MaxLat float64
+}
+
+func (b bbox) contains(lng float64, lat float64) bool {
+	return lng >= b.MinLng && lng <= b.MaxLng && lat >= b.MinLat && lat <= b.MaxLat
+}
+
+// featureBounds returns the box around every outer ring of the feature. A
+// feature without coordinates gets an empty box that contains nothing.
+func featureBounds(feature Feature) bbox {
+	b := bbox{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, polygon := range feature.Geometry.Polygons {
+		if len(polygon) == 0 {
+			continue
+		}
+		for _, point := range polygon[0] {
+			b.MinLng, b.MaxLng = math.Min(b.MinLng, point[0]), math.Max(b.MaxLng, point[0])
+			b.MinLat, b.MaxLat = math.Min(b.MinLat, point[1]), math.Max(b.MaxLat, point[1])
+		}
+	}
+	return b
+}
+
+// crossesAntimeridian reports whether the ring spans more than 180 degrees
+// of longitude, which for real zones means it wraps around ±180.
+func crossesAntimeridian(ring [][]float64) bool {
+	if len(ring) == 0 {
+		return false
+	}
+	minLng, maxLng := ring[0][0], ring[0][0]
+	for _, point := range ring {
+		minLng, maxLng = math.Min(minLng, point[0]), math.Max(maxLng, point[0])
+	}
+	return maxLng-minLng > 180
+}
+
+// shiftLongitudes returns a copy of polygon with negative longitudes moved
+// into (180, 360).
+func shiftLongitudes(polygon Polygon) Polygon {
+	shifted := make(Polygon, len(polygon))
+	for i, ring := range polygon {
+		shifted[i] = make([][]float64, len(ring))
+		for j, point := range ring {
+			p := append([]float64(nil), point...)
+			if p[0] < 0 {
+				p[0] += 360
+			}
+			shifted[i][j] = p
+		}
+	}
+	return shifted
+}
+
+// closeRing returns ring with its first position appended if the ring does
+// not already end where it starts. A closed ring is returned unchanged.
+func closeRing(ring [][]float64) [][]float64 {
+	if len(ring) == 0 {
+		return ring
+	}
+	first, last := ring[0], ring[len(ring)-1]
+	if first[0] == last[0] && first[1] == last[1] {
+		return ring
+	}
+	closed := make([][]float64, len(ring), len(ring)+1)
+	copy(closed, ring)
+	return append(closed, first)
+}
+
+// closeRings returns polygon with every ring closed by closeRing.
+func closeRings(polygon Polygon) Polygon {
+	closed := make(Polygon, len(polygon))
+	for i, ring := range polygon {
+		closed[i] = closeRing(ring)
+	}
+	return closed
+}
+
+// simplifyRing applies Douglas-Peucker simplification to a closed ring,
+// dropping vertices that lie within tolerance degrees of the simplified
+// outline. The ring is split at the vertex farthest from its first position
+// so that both halves have distinct endpoints. Rings that would collapse
+// below a triangle are returned unchanged.
+func simplifyRing(ring [][]float64, tolerance float64) [][]float64 {
+	n := len(ring)
+	if tolerance <= 0 || n <= 4 {
+		return ring
+	}
+
+	farthest, maxDistance := 0, -1.0
+	for i := 1; i < n-1; i++ {
+		dx, dy := ring[i][0]-ring[0][0], ring[i][1]-ring[0][1]
+		if d := dx*dx + dy*dy; d > maxDistance {
+			farthest, maxDistance = i, d
+		}
+	}
+
+	keep := make([]bool, n)
+	keep[0], keep[farthest], keep[n-1] = true, true, true
+	douglasPeucker(ring, 0, farthest, tolerance, keep)
+	douglasPeucker(ring, farthest, n-1, tolerance, keep)
+
+	simplified := make([][]float64, 0, n)
+	for i, point := range ring {
+		if keep[i] {
+			simplified = append(simplified, point)
+		}
+	}
+	if len(simplified) < 4 {
+		return ring
+	}
+	return simplified
+}
+
+// douglasPeucker marks in keep the vertices strictly between first and last
+// that must stay for the outline to remain within tolerance of ring.
+func douglasPeucker(ring [][]float64, first int, last int, tolerance float64, keep []bool) {
+	if last-first < 2 {
+		return
+	}
+	index, maxDistance := 0, 0.0
+	for i := first + 1; i < last; i++ {
+		if d := segmentDistance(ring[i], ring[first], ring[last]); d > maxDistance {
+			index, maxDistance = i, d
+		}
+	}
+	if maxDistance <= tolerance {
+		return
+	}
+	keep[index] = true
+	douglasPeucker(ring, first, index, tolerance, keep)
+	douglasPeucker(ring, index, last, tolerance, keep)
+}
+
+// segmentDistance returns the planar distance, in degrees, from p to the
+// segment from a to b.
+func segmentDistance(p []float64, a []float64, b []float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	t := 0.0
+	if lengthSq := dx*dx + dy*dy; lengthSq > 0 {
+		t = math.Max(0, math.Min(1, ((p[0]-a[0])*dx+(p[1]-a[1])*dy)/lengthSq))
+	}
+	return math.Hypot(p[0]-(a[0]+t*dx), p[1]-(a[1]+t*dy))
+}
+
+// simplifyPolygon applies simplifyRing to every ring of polygon.
+func simplifyPolygon(polygon Polygon, tolerance float64) Polygon {
+	simplified := make(Polygon, len(polygon))
+	for i, ring := range polygon {
+		simplified[i] = simplifyRing(ring, tolerance)
+	}
+	return simplified
+}
+
+// wrapLng undoes shiftLongitudes for a single longitude.
+func wrapLng(lng float64) float64 {
+	if lng > 180 {
+		return lng - 360
+	}
+	return lng
+}
+
+const earthRadiusMeters = 6371008.8
+
+// haversineMeters returns the great-circle distance between two points.
+func haversineMeters(lng1 float64, lat1 float64, lng2 float64, lat2 float64) float64 {
+	phi1, phi2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dPhi := phi2 - phi1
+	dLambda := (lng2 - lng1) * math.Pi / 180
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(math.Min(1, a)))
+}
+
+// distanceToPolygon returns the distance in meters from the point to the
+// nearest edge of ring. The closest point on each edge is found in a local
+// equirectangular projection around the query point, and the distance to it
+// is then measured with haversineMeters.
+func distanceToPolygon(lng float64, lat float64, ring [][]float64) float64 {
+	n := len(ring)
+	if n == 0 {
+		return math.Inf(1)
+	}
+	kx := math.Cos(lat * math.Pi / 180)
+
+	nearest := math.Inf(1)
+	for i := 0; i < n; i++ {
+		a, b := ring[i], ring[(i+1)%n]
+		ax, ay := (a[0]-lng)*kx, a[1]-lat
+		bx, by := (b[0]-lng)*kx, b[1]-lat
+		dx, dy := bx-ax, by-ay
+
+		t := 0.0
+		if lengthSq := dx*dx + dy*dy; lengthSq > 0 {
+			t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/lengthSq))
+		}
+		px, py := ax+t*dx, ay+t*dy
+
+		if d := haversineMeters(lng, lat, lng+px/kx, lat+py); d < nearest {
+			nearest = d
+		}
+	}
+	return nearest
+}
+
+// centroid returns the centroid of ring using the shoelace formula. Rings
+// with zero area fall back to the average of their vertices.
+func centroid(ring [][]float64) Point {
+	n := len(ring)
+	if n == 0 {
+		return Point{}
+	}
+
+	var area2, cx, cy float64
+	for i := 0; i < n; i++ {
+		x0, y0 := ring[i][0], ring[i][1]
+		x1, y1 := ring[(i+1)%n][0], ring[(i+1)%n][1]
+		cross := x0*y1 - x1*y0
+		area2 += cross
+		cx += (x0 + x1) * cross
+		cy += (y0 + y1) * cross
+	}
+	if area2 == 0 {
+		var sx, sy float64
+		for _, point := range ring {
+			sx += point[0]
+			sy += point[1]
+		}
+		return Point{Lng: sx / float64(n), Lat: sy / float64(n)}
+	}
+	return Point{Lng: cx / (3 * area2), Lat: cy / (3 * area2)}
+}
+
+// signedRingArea returns the planar area of ring in square degrees: positive
+// when the ring is counter-clockwise, negative when clockwise.
+func signedRingArea(ring [][]float64) float64 {
+	n := len(ring)
+	var area2 float64
+	for i := 0; i < n; i++ {
+		area2 += ring[i][0]*ring[(i+1)%n][1] - ring[(i+1)%n][0]*ring[i][1]
+	}
+	return area2 / 2
+}
+
+// ringArea returns the unsigned planar area of ring in square degrees.
+func ringArea(ring [][]float64) float64 {
+	return math.Abs(signedRingArea(ring))
+}
+
+// ringIsClockwise reports whether ring winds clockwise.
+func ringIsClockwise(ring [][]float64) bool {
+	return signedRingArea(ring) < 0
+}
+
+// normalizeWinding returns polygon with its outer ring counter-clockwise and
+// its holes clockwise, as the GeoJSON right-hand rule requires, together
+// with the indexes of the rings it reversed.
+func normalizeWinding(polygon Polygon) (Polygon, []int) {
+	var flipped []int
+	normalized := make(Polygon, len(polygon))
+	for i, ring := range polygon {
+		normalized[i] = ring
+		if wantClockwise := i > 0; ringIsClockwise(ring) != wantClockwise && signedRingArea(ring) != 0 {
+			normalized[i] = reverseRing(ring)
+			flipped = append(flipped, i)
+		}
+	}
+	return normalized, flipped
+}
+
+// reverseRing returns a copy of ring in the opposite order.
+func reverseRing(ring [][]float64) [][]float64 {
+	reversed := make([][]float64, len(ring))
+	for i, point := range ring {
+		reversed[len(ring)-1-i] = point
+	}
+	return reversed
+}
+
+// featureSize returns the planar area of the feature in square degrees, with
+// holes subtracted.
+func featureSize(feature Feature) float64 {
+	var size float64
+	for _, polygon := range feature.Geometry.Polygons {
+		for i, ring := range polygon {
+			if i == 0 {
+				size += ringArea(ring)
+			} else {
+				size -= ringArea(ring)
+			}
+		}
+	}
+	return size
+}
+
+// polygonAreaKm2 returns the unsigned area of ring in square kilometers. The
+// ring is projected onto a plane tangent at its mean latitude, scaling
+// longitudes by the cosine of that latitude, and measured with the shoelace
+// formula. For city zones a few kilometers across the error is well under
+// 0.1%; it grows with the ring's north-south extent and is not meant for
+// regions spanning more than a degree or two of latitude.
+func polygonAreaKm2(ring [][]float64) float64 {
+	n := len(ring)
+	if n < 3 {
+		return 0
+	}
+	var meanLat float64
+	for _, point := range ring {
+		meanLat += point[1]
+	}
+	meanLat /= float64(n)
+
+	const radiansPerDegree = math.Pi / 180
+	kmPerDegree := earthRadiusMeters / 1000 * radiansPerDegree
+	kx := kmPerDegree * math.Cos(meanLat*radiansPerDegree)
+	return ringArea(ring) * kx * kmPerDegree
+}
+
+// featureAreaKm2 returns the area of the feature in square kilometers, with
+// holes subtracted.
+func featureAreaKm2(feature Feature) float64 {
+	var total float64
+	for _, polygon := range feature.Geometry.Polygons {
+		for i, ring := range polygon {
+			if i == 0 {
+				total += polygonAreaKm2(ring)
+			} else {
+				total -= polygonAreaKm2(ring)
+			}
+		}
+	}
+	return total
+}
+
+// polygonPerimeterKm returns the length of ring in kilometers, the sum of
+// the great-circle lengths of its edges. An unclosed ring is measured as if
+// closed.
+func polygonPerimeterKm(ring [][]float64) float64 {
+	n := len(ring)
+	if n < 2 {
+		return 0
+	}
+	var total float64
+	for i := 0; i < n; i++ {
+		a, b := ring[i], ring[(i+1)%n]
+		total += haversineMeters(a[0], a[1], b[0], b[1])
+	}
+	return total / 1000
+}
+
+// featurePerimeterKm returns the total boundary length of the feature in
+// kilometers. Holes are part of a zone's boundary, so their rings are added
+// to the outer rings rather than left out.
+func featurePerimeterKm(feature Feature) float64 {
+	var total float64
+	for _, polygon := range feature.Geometry.Polygons {
+		for _, ring := range polygon {
+			total += polygonPerimeterKm(ring)
+		}
+	}
+	return total
+}
+
+// featureCentroid returns the centroid of the feature's outer rings, each
+// weighted by its area.
+func featureCentroid(feature Feature) Point {
+	var total, lng, lat float64
+	var first Point
+	for i, polygon := range feature.Geometry.Polygons {
+		if len(polygon) == 0 {
+			continue
+		}
+		c := centroid(polygon[0])
+		if i == 0 {
+			first = c
+		}
+		a := ringArea(polygon[0])
+		total += a
+		lng += c.Lng * a
+		lat += c.Lat * a
+	}
+	center := first
+	if total != 0 {
+		center = Point{Lng: lng / total, Lat: lat / total}
+	}
+	center.Lng = wrapLng(center.Lng)
+	return center
+}
+
+// polygonContains reports whether the point lies within the outer ring of
+// polygon and outside every one of its holes.
+func polygonContains(lng float64, lat float64, polygon Polygon) bool {
+	if len(polygon) == 0 || !isPointInPolygon(lng, lat, polygon[0]) {
+		return false
+	}
+	for _, hole := range polygon[1:] {
+		if isPointInPolygon(lng, lat, hole) {
+			return false
+		}
+	}
+	return true
+}
+
+// isPointInPolygon casts a ray from the point towards +lng and counts how
+// many edges of the ring it crosses.
+//
+// Boundary rule: each region is closed on its south and west sides and open
+// on its north and east sides. A point on a vertex or edge is therefore
+// inside exactly when the ring's interior lies immediately north-east of it,
+// so a point on the border shared by two adjacent zones belongs to exactly
+// one of them. Edges are always evaluated from their lower endpoint so that
+// a shared edge gives the same answer whichever ring it belongs to.
+func isPointInPolygon(lng float64, lat float64, polygon [][]float64) bool {
+	n := len(polygon)
+	if n < 3 {
+		return false
+	}
+	inside := false
+
+	for i := 0; i < n; i++ {
+		p1x, p1y := polygon[i][0], polygon[i][1]
+		p2x, p2y := polygon[(i+1)%n][0], polygon[(i+1)%n][1]
+		if p1y > p2y {
+			p1x, p1y, p2x, p2y = p2x, p2y, p1x, p1y
+		}
+		// Half-open in lat: the edge spans [p1y, p2y), so horizontal edges
+		// never count and a vertex is counted for only one of its edges.
+		if lat < p1y || lat >= p2y {
+			continue
+		}
+		xinters := (lat-p1y)*(p2x-p1x)/(p2y-p1y) + p1x
+		if lng < xinters {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Results of classifyPoint.
+const (
+	pointOutside = iota
+	pointOnBoundary
+	pointInside
+)
+
+// boundaryEpsilonMeters is how close to an edge a point must be for
+// classifyPoint to report it as on the boundary.
+var boundaryEpsilonMeters = 0.5
+
+// classifyPoint reports whether the point is strictly inside ring, within
+// boundaryEpsilonMeters of one of its edges, or outside it.
+func classifyPoint(lng float64, lat float64, ring [][]float64) int {
+	if len(ring) < 3 {
+		return pointOutside
+	}
+	if distanceToPolygon(lng, lat, ring) <= boundaryEpsilonMeters {
+		return pointOnBoundary
+	}
+	if isPointInPolygon(lng, lat, ring) {
+		return pointInside
+	}
+	return pointOutside
+}
+
+// classifyPolygon applies classifyPoint to the outer ring and holes of
+// polygon. A point on the edge of a hole is on the polygon's boundary.
+func classifyPolygon(lng float64, lat float64, polygon Polygon) int {
+	if len(polygon) == 0 {
+		return pointOutside
+	}
+	class := classifyPoint(lng, lat, polygon[0])
+	if class != pointInside {
+		return class
+	}
+	for _, hole := range polygon[1:] {
+		switch classifyPoint(lng, lat, hole) {
+		case pointOnBoundary:
+			return pointOnBoundary
+		case pointInside:
+			return pointOutside
+		}
+	}
+	return pointInside
+}
+
+// containmentNames are the values of the containment response field.
+var containmentNames = map[int]string{
+	pointOutside:    "outside",
+	pointOnBoundary: "boundary",
+	pointInside:     "inside",
+}
+
+// flatPolygon is a polygon with all of its rings packed into one slice as
+// lng0, lat0, lng1, lat1, ... so the containment loop walks contiguous
+// memory. Ring i occupies coords[offsets[i]:offsets[i+1]].
+type flatPolygon struct {
+	coords  []float64
+	offsets []int
+}
+
+func newFlatPolygon(polygon Polygon) flatPolygon {
+	flat := flatPolygon{offsets: make([]int, 0, len(polygon)+1)}
+	for _, ring := range polygon {
+		flat.offsets = append(flat.offsets, len(flat.coords))
+		for _, point := range ring {
+			flat.coords = append(flat.coords, point[0], point[1])
+		}
+	}
+	flat.offsets = append(flat.offsets, len(flat.coords))
+	return flat
+}
+
+func (p flatPolygon) ring(i int) []float64 {
+	return p.coords[p.offsets[i]:p.offsets[i+1]]
+}
+
+// contains is polygonContains for a flat polygon, using the configured
+// pointInPolygon algorithm.
+func (p flatPolygon) contains(lng float64, lat float64) bool {
+	return pointInPolygon.Contains(lng, lat, p)
+}
+
+// containsWith reports whether the point is inside the outer ring and
+// outside every hole, as decided by ringContains.
+func (p flatPolygon) containsWith(lng float64, lat float64, ringContains func(lng, lat float64, ring []float64) bool) bool {
+	rings := len(p.offsets) - 1
+	if rings < 1 || !ringContains(lng, lat, p.ring(0)) {
+		return false
+	}
+	for i := 1; i < rings; i++ {
+		if ringContains(lng, lat, p.ring(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isPointInFlatRing is isPointInPolygon for a ring packed as lng, lat pairs.
+// It follows the same boundary rule.
+func isPointInFlatRing(lng float64, lat float64, ring []float64) bool {
+	n := len(ring)
+	if n < 6 {
+		return false
+	}
+	inside := false
+
+	for i := 0; i < n; i += 2 {
+		j := i + 2
+		if j == n {
+			j = 0
+		}
+		p1x, p1y := ring[i], ring[i+1]
+		p2x, p2y := ring[j], ring[j+1]
+		if p1y > p2y {
+			p1x, p1y, p2x, p2y = p2x, p2y, p1x, p1y
+		}
+		if lat < p1y || lat >= p2y {
+			continue
+		}
+		xinters := (lat-p1y)*(p2x-p1x)/(p2y-p1y) + p1x
+		if lng < xinters {
+			inside = !inside
+		}
+	}
+	return inside
+}
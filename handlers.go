@@ -0,0 +1,1365 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (g *Geocoder) geocodeHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodOptions:
+		return
+	case http.MethodGet, http.MethodPost:
+	default:
+		w.Header().Set("Allow", "GET, POST, OPTIONS")
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	start := time.Now()
+	result := resultError
+	defer func() {
+		geocodeRequests.WithLabelValues(result).Inc()
+		geocodeLatency.Observe(time.Since(start).Seconds())
+	}()
+
+	if !g.Available() {
+		writeUnavailable(w)
+		return
+	}
+
+	decimals := defaultDecimals
+	if value := r.URL.Query().Get("decimals"); value != "" {
+		var err error
+		decimals, err = strconv.Atoi(value)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid decimals parameter: must be a whole number of decimal places")
+			return
+		}
+		decimals = min(max(decimals, 0), maxDecimals)
+	}
+
+	precision := defaultGeohashPrecision
+	if value := r.URL.Query().Get("precision"); value != "" {
+		var err error
+		precision, err = strconv.Atoi(value)
+		if err != nil || precision < 1 || precision > maxGeohashPrecision {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid precision parameter: must be between 1 and %d", maxGeohashPrecision))
+			return
+		}
+	}
+
+	filter, err := filterFromQuery(r.URL.Query(), g.currentAreas())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	lats, lngs := r.URL.Query()["lat"], r.URL.Query()["lng"]
+	multiPoint := r.Method == http.MethodGet && (len(lats) > 1 || len(lngs) > 1)
+	if filter != nil && (multiPoint || r.URL.Query().Get("address") != "") {
+		writeJSONError(w, http.StatusBadRequest, "Filters are only supported when reverse geocoding a single point")
+		return
+	}
+
+	if address := r.URL.Query().Get("address"); address != "" {
+		result = resultFallback
+		if g.forwardGeocode(w, address, decimals) {
+			result = resultHit
+		}
+		return
+	}
+
+	// Repeated lat and lng parameters geocode several points in one GET.
+	if multiPoint {
+		ctx, cancel := lookupContext(r)
+		defer cancel()
+		matched, err := g.geocodePoints(ctx, w, lats, lngs, precision, decimals)
+		if isLookupError(err) {
+			writeLookupError(w, err)
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		result = resultFallback
+		if matched {
+			result = resultHit
+		}
+		return
+	}
+
+	lat, lng, err := pointFromRequest(w, r)
+	if err != nil {
+		writeJSONError(w, bodyErrorStatus(err), err.Error())
+		return
+	}
+
+	algorithm := pointInPolygon
+	if rule := r.URL.Query().Get("fillRule"); rule != "" {
+		var ok bool
+		if algorithm, ok = fillRules[rule]; !ok {
+			writeJSONError(w, http.StatusBadRequest, "Invalid fillRule parameter: must be evenodd or nonzero")
+			return
+		}
+	}
+
+	version := g.currentAreas().etag
+	ctx, cancel := lookupContext(r)
+	defer cancel()
+	lookupStart := time.Now()
+	var features []*area
+	all := r.URL.Query().Get("all") == "true"
+	switch {
+	case filter != nil:
+		// Filtered lookups bypass the cache, which holds the unfiltered
+		// match of each point.
+		features, err = g.findAllFeaturesContext(ctx, algorithm, lng, lat)
+		features = filter.apply(features)
+		if !all && len(features) > 1 {
+			features = features[:1]
+		}
+	case all:
+		features, err = g.findAllFeaturesContext(ctx, algorithm, lng, lat)
+	default:
+		var feature *area
+		if feature, err = g.findFeatureContext(ctx, algorithm, lng, lat); feature != nil {
+			features = []*area{feature}
+		}
+	}
+	if err != nil {
+		writeLookupError(w, err)
+		return
+	}
+	classify := r.URL.Query().Get("classify") == "true"
+	if len(features) == 0 && classify {
+		// Boundaries are open on their north and east sides, so a point on
+		// such an edge matches nothing but is still on that area's boundary.
+		if feature, distance := g.findNearestFeatureWhere(lng, lat, filter.keep); feature != nil && distance <= boundaryEpsilonMeters {
+			features = []*area{feature}
+		}
+	}
+	if len(features) == 0 && r.URL.Query().Get("nearest") == "true" {
+		if feature, _ := g.findNearestFeatureWhere(lng, lat, filter.keep); feature != nil {
+			features = []*area{feature}
+		}
+	}
+	setProcessingTime(w, time.Since(lookupStart))
+	result = resultFallback
+	if len(features) > 0 {
+		result = resultHit
+	}
+	if r.Method == http.MethodGet && notModified(w, r, version, features) {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if len(features) == 0 && wantsZeroResults(r) {
+		// Without a fallback locality there is nothing to render as a
+		// Feature or as WKT.
+		if format == "geojson" || format == "wkt" {
+			writeJSONError(w, http.StatusNotFound, "No area contains the point")
+			return
+		}
+		writeJSON(w, http.StatusOK, GeocodeResponse{Results: []Result{}, Status: "ZERO_RESULTS"})
+		return
+	}
+
+	switch format {
+	case "geojson":
+		var feature *area
+		if len(features) > 0 {
+			feature = features[0]
+		}
+		writeGeoJSONFeature(w, feature, lat, lng)
+		return
+	case "wkt":
+		if len(features) == 0 {
+			writeJSONError(w, http.StatusNotFound, "No area contains the point")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, geometryToWKT(features[0].Geometry))
+		return
+	}
+
+	if len(features) == 0 {
+		// A nil feature renders as the fallback locality.
+		features = []*area{nil}
+	}
+	results := make([]Result, len(features))
+	for i, feature := range features {
+		results[i] = newResult(feature, lat, lng)
+		results[i].Geohash = geohashEncode(lat, lng, precision)
+		if feature != nil && r.URL.Query().Get("distance") == "true" {
+			distance := featureDistance(feature, lng, lat)
+			results[i].DistanceMeters = &distance
+		}
+		if feature != nil && r.URL.Query().Get("viewport") == "true" {
+			results[i].Geometry.Viewport = newViewport(feature.bounds)
+		}
+		if feature != nil && r.URL.Query().Get("props") == "true" {
+			results[i].Properties = feature.Properties.all()
+		}
+		if feature != nil && r.URL.Query().Get("debug") == "true" {
+			if polygon := feature.locateWith(algorithm, lng, lat); polygon >= 0 {
+				results[i].Debug = &ResultDebug{Polygon: polygon, Ring: 0}
+			}
+		}
+		if classify {
+			class := pointOutside
+			if feature != nil {
+				class = feature.classify(lng, lat)
+			}
+			results[i].Containment = containmentNames[class]
+		}
+		results[i].roundLocations(decimals)
+	}
+
+	writeJSON(w, http.StatusOK, GeocodeResponse{
+		Results: results,
+		Status:  "OK",
+	})
+}
+
+// geocodePoints writes one result per lat/lng pair, in the order given, and
+// reports whether any point matched an area. Nothing is written if it
+// returns an error.
+func (g *Geocoder) geocodePoints(ctx context.Context, w http.ResponseWriter, lats []string, lngs []string, precision int, decimals int) (bool, error) {
+	if len(lats) != len(lngs) {
+		return false, fmt.Errorf("Mismatched lat and lng parameters: got %d lat and %d lng", len(lats), len(lngs))
+	}
+	if len(lats) > maxBatchSize {
+		return false, fmt.Errorf("Too many points: %d, limit is %d", len(lats), maxBatchSize)
+	}
+
+	points := make([]Point, len(lats))
+	for i := range lats {
+		lat, err := parseCoordinate(lats[i])
+		if err != nil {
+			return false, fmt.Errorf("Point %d: Invalid lat parameter", i)
+		}
+		lng, err := parseCoordinate(lngs[i])
+		if err != nil {
+			return false, fmt.Errorf("Point %d: Invalid lng parameter", i)
+		}
+		if err := validateCoordinates(lat, lng); err != nil {
+			return false, fmt.Errorf("Point %d: %v", i, err)
+		}
+		points[i] = Point{Lng: lng, Lat: lat}
+	}
+
+	lookupStart := time.Now()
+	features, err := g.findFeatures(ctx, points)
+	if err != nil {
+		return false, err
+	}
+	setProcessingTime(w, time.Since(lookupStart))
+
+	matched := false
+	results := make([]Result, len(points))
+	for i, point := range points {
+		matched = matched || features[i] != nil
+		results[i] = newResult(features[i], point.Lat, point.Lng)
+		results[i].Geohash = geohashEncode(point.Lat, point.Lng, precision)
+		results[i].roundLocations(decimals)
+	}
+
+	writeJSON(w, http.StatusOK, GeocodeResponse{Results: results, Status: "OK"})
+	return matched, nil
+}
+
+// lookupTimeout bounds the area lookups of one request; 0 disables it.
+var lookupTimeout = 5 * time.Second
+
+// lookupContext returns the context area lookups for r run under: r's own,
+// cut off after lookupTimeout.
+func lookupContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if lookupTimeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), lookupTimeout)
+}
+
+// isLookupError reports whether err is a lookup abandoned because its
+// context was done.
+func isLookupError(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
+// writeLookupError reports a lookup given up on: 504 if it ran past
+// lookupTimeout, 503 if the client went away first.
+func writeLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeJSONError(w, http.StatusGatewayTimeout, "Lookup timed out")
+		return
+	}
+	writeJSONError(w, http.StatusServiceUnavailable, "Lookup canceled")
+}
+
+// setProcessingTime reports how long the area lookups for a request took,
+// as opposed to the whole request, in the X-Processing-Time-Ms header.
+func setProcessingTime(w http.ResponseWriter, elapsed time.Duration) {
+	ms := float64(elapsed) / float64(time.Millisecond)
+	w.Header().Set("X-Processing-Time-Ms", strconv.FormatFloat(ms, 'f', 3, 64))
+}
+
+// writeUnavailable reports that no area data is loaded, so that clients do
+// not mistake an outage for a point outside every area.
+func writeUnavailable(w http.ResponseWriter) {
+	writeJSONError(w, http.StatusServiceUnavailable, "Area data unavailable")
+}
+
+// geoJSONFeature is the response body for ?format=geojson. Geometry is nil
+// when the point did not match any area.
+type geoJSONFeature struct {
+	Type       string     `json:"type"`
+	Geometry   *Geometry  `json:"geometry"`
+	Properties Properties `json:"properties"`
+}
+
+func writeGeoJSONFeature(w http.ResponseWriter, feature *area, lat float64, lng float64) {
+	response := geoJSONFeature{
+		Type:       "Feature",
+		Properties: Properties{Name: fallbackLocality, Id: fallbackPlaceID(lat, lng)},
+	}
+	if feature != nil {
+		response.Geometry = &feature.Geometry
+		response.Properties = feature.Properties
+	}
+
+	writeGeoJSON(w, http.StatusOK, response)
+}
+
+// propertyFilter restricts a lookup to the features whose properties have
+// all of the given values.
+type propertyFilter map[string]string
+
+// keep reports whether feature passes the filter. A nil filter keeps every
+// feature.
+func (f propertyFilter) keep(feature *area) bool {
+	for key, value := range f {
+		if !feature.Properties.matches(key, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply returns the features that pass the filter, in order.
+func (f propertyFilter) apply(features []*area) []*area {
+	var kept []*area
+	for _, feature := range features {
+		if f.keep(feature) {
+			kept = append(kept, feature)
+		}
+	}
+	return kept
+}
+
+// filterFromQuery returns the property filter of a request, or nil if it
+// has none: ?filterKey=&filterValue= matches any property, and ?city= is
+// short for filterKey=city. A key that no loaded feature has is an error
+// rather than a filter that silently matches nothing.
+func filterFromQuery(query url.Values, set *areaSet) (propertyFilter, error) {
+	filter := propertyFilter{}
+	if query.Has("city") {
+		filter["city"] = query.Get("city")
+	}
+	key := query.Get("filterKey")
+	switch {
+	case key == "" && query.Has("filterValue"):
+		return nil, errors.New("Invalid filterValue parameter: filterKey is required")
+	case key != "" && !query.Has("filterValue"):
+		return nil, errors.New("Invalid filterKey parameter: filterValue is required")
+	case key != "":
+		filter[key] = query.Get("filterValue")
+	}
+	if len(filter) == 0 {
+		return nil, nil
+	}
+	for key := range filter {
+		if set == nil || !set.propertyKeys[key] {
+			return nil, fmt.Errorf("Invalid filter: no area has a %q property", key)
+		}
+	}
+	return filter, nil
+}
+
+// bodyPoint is a point in a JSON request body. Its coordinates are pointers
+// so that a missing one is rejected instead of read as 0.
+type bodyPoint struct {
+	Lat *float64 `json:"lat"`
+	Lng *float64 `json:"lng"`
+}
+
+// point returns the point, or the error the GET handlers give for a missing
+// lat or lng parameter.
+func (p bodyPoint) point() (Point, error) {
+	if p.Lat == nil || p.Lng == nil {
+		return Point{}, fmt.Errorf("Missing lat or lng parameters")
+	}
+	return Point{Lng: *p.Lng, Lat: *p.Lat}, validateCoordinates(*p.Lat, *p.Lng)
+}
+
+// pointFromRequest reads the query point from a JSON body for POST requests
+// sent as application/json, and otherwise from either the latlng query
+// parameter or the separate lat and lng parameters.
+func pointFromRequest(w http.ResponseWriter, r *http.Request) (float64, float64, error) {
+	if r.Method == http.MethodPost && isJSONContent(r) {
+		var body bodyPoint
+		if err := decodeBody(w, r, &body); err != nil {
+			return 0, 0, err
+		}
+		point, err := body.point()
+		return point.Lat, point.Lng, err
+	}
+
+	// Google clients send the point as a single latlng=lat,lng parameter.
+	if latlng := r.URL.Query().Get("latlng"); latlng != "" {
+		latStr, lngStr, _ := strings.Cut(latlng, ",")
+		lat, latErr := parseCoordinate(latStr)
+		lng, lngErr := parseCoordinate(lngStr)
+		if latErr != nil || lngErr != nil {
+			return 0, 0, fmt.Errorf("Invalid latlng parameter: expected lat,lng")
+		}
+		return lat, lng, validateCoordinates(lat, lng)
+	}
+
+	return pointFromQuery(r.URL.Query(), "lat", "lng")
+}
+
+// pointFromQuery parses the point in the latKey and lngKey query parameters.
+func pointFromQuery(query url.Values, latKey string, lngKey string) (float64, float64, error) {
+	latStr := query.Get(latKey)
+	lngStr := query.Get(lngKey)
+
+	if latStr == "" || lngStr == "" {
+		return 0, 0, fmt.Errorf("Missing %s or %s parameters", latKey, lngKey)
+	}
+
+	lat, err := parseCoordinate(latStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid %s parameter", latKey)
+	}
+
+	lng, err := parseCoordinate(lngStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid %s parameter", lngKey)
+	}
+
+	return lat, lng, validateCoordinates(lat, lng)
+}
+
+// parseCoordinate parses a lat or lng query value, tolerating surrounding
+// whitespace and a single trailing comma left behind by sloppy clients.
+func parseCoordinate(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimSpace(strings.TrimSuffix(value, ","))
+	return strconv.ParseFloat(value, 64)
+}
+
+// maxBodyBytes caps the size of request bodies read by decodeBody.
+var maxBodyBytes int64 = 1 << 20
+
+// errBodyTooLarge is returned by decodeBody for bodies over maxBodyBytes.
+var errBodyTooLarge = errors.New("Request body too large")
+
+// decodeBody decodes the JSON request body into v, reading at most
+// maxBodyBytes of it.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		if tooLarge := tooLargeError(err); tooLarge != nil {
+			return tooLarge
+		}
+		return fmt.Errorf("Invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+// tooLargeError returns errBodyTooLarge, naming the limit, if err came from
+// a body cut off by http.MaxBytesReader, and nil otherwise.
+func tooLargeError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("%w: limit is %d bytes", errBodyTooLarge, tooLarge.Limit)
+	}
+	return nil
+}
+
+// bodyErrorStatus is the status code for a request rejected with err: 413
+// for an oversized body and 400 for anything else.
+func bodyErrorStatus(err error) int {
+	if errors.Is(err, errBodyTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+func isJSONContent(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// validateCoordinates rejects points outside the valid Earth ranges, and NaN
+// and infinite values, which strconv.ParseFloat accepts and which would
+// otherwise fail every comparison and fall through to the fallback locality.
+func validateCoordinates(lat float64, lng float64) error {
+	if !isFinite(lat) {
+		return fmt.Errorf("Invalid lat parameter: must be a finite number")
+	}
+	if !isFinite(lng) {
+		return fmt.Errorf("Invalid lng parameter: must be a finite number")
+	}
+	if lat < -90 || lat > 90 {
+		return fmt.Errorf("Invalid lat parameter: must be between -90 and 90")
+	}
+	if lng < -180 || lng > 180 {
+		return fmt.Errorf("Invalid lng parameter: must be between -180 and 180")
+	}
+	return nil
+}
+
+func isFinite(x float64) bool {
+	return !math.IsNaN(x) && !math.IsInf(x, 0)
+}
+
+// maxBatchSize caps the number of points accepted by batchGeocodeHandler.
+var maxBatchSize = 1000
+
+// batchGeocodeHandler reverse geocodes a JSON array of {lat, lng} points and
+// returns one result per point, in the order they were given.
+func (g *Geocoder) batchGeocodeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if !g.Available() {
+		writeUnavailable(w)
+		return
+	}
+
+	var points []bodyPoint
+	if err := decodeBody(w, r, &points); err != nil {
+		writeJSONError(w, bodyErrorStatus(err), err.Error())
+		return
+	}
+	if len(points) > maxBatchSize {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Batch too large: %d points, limit is %d", len(points), maxBatchSize))
+		return
+	}
+
+	lookups := make([]Point, len(points))
+	for i, point := range points {
+		var err error
+		if lookups[i], err = point.point(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Point %d: %v", i, err))
+			return
+		}
+	}
+	ctx, cancel := lookupContext(r)
+	defer cancel()
+	lookupStart := time.Now()
+	features, err := g.findFeatures(ctx, lookups)
+	if err != nil {
+		writeLookupError(w, err)
+		return
+	}
+	setProcessingTime(w, time.Since(lookupStart))
+
+	results := make([]Result, len(lookups))
+	for i, point := range lookups {
+		results[i] = newResult(features[i], point.Lat, point.Lng)
+		results[i].roundLocations(defaultDecimals)
+	}
+
+	writeJSON(w, http.StatusOK, GeocodeResponse{Results: results, Status: "OK"})
+}
+
+// wktGeocodeHandler reverse geocodes a Well-Known Text point sent as the
+// request body, e.g. POINT(41.86 9.59). Note that WKT puts the longitude
+// first; see parseWKTPoint.
+func (g *Geocoder) wktGeocodeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if !g.Available() {
+		writeUnavailable(w)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		if tooLarge := tooLargeError(err); tooLarge != nil {
+			err = tooLarge
+		}
+		writeJSONError(w, bodyErrorStatus(err), err.Error())
+		return
+	}
+	lng, lat, err := parseWKTPoint(string(body))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid WKT: "+err.Error())
+		return
+	}
+	if err := validateCoordinates(lat, lng); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := lookupContext(r)
+	defer cancel()
+	feature, err := g.findFeatureContext(ctx, pointInPolygon, lng, lat)
+	if err != nil {
+		writeLookupError(w, err)
+		return
+	}
+	if feature == nil && wantsZeroResults(r) {
+		writeJSON(w, http.StatusOK, GeocodeResponse{Results: []Result{}, Status: "ZERO_RESULTS"})
+		return
+	}
+	result := newResult(feature, lat, lng)
+	result.roundLocations(defaultDecimals)
+	writeJSON(w, http.StatusOK, GeocodeResponse{Results: []Result{result}, Status: "OK"})
+}
+
+// routeHandler geocodes an ordered route and returns the sequence of zones
+// it passes through. The route is either a POSTed JSON array of {lat, lng}
+// points or a Google encoded polyline in ?polyline=, which may also be sent
+// with GET. Consecutive points in the same zone are collapsed into one entry
+// giving the index of the first of them; stretches outside every area appear
+// as entries marked outside.
+func (g *Geocoder) routeHandler(w http.ResponseWriter, r *http.Request) {
+	polyline := r.URL.Query().Get("polyline")
+	if r.Method != http.MethodPost && !(r.Method == http.MethodGet && polyline != "") {
+		w.Header().Set("Allow", "GET, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if !g.Available() {
+		writeUnavailable(w)
+		return
+	}
+
+	var points []Point
+	if polyline != "" {
+		var err error
+		if points, err = decodePolyline(polyline); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid polyline parameter: "+err.Error())
+			return
+		}
+	} else {
+		var body []bodyPoint
+		if err := decodeBody(w, r, &body); err != nil {
+			writeJSONError(w, bodyErrorStatus(err), err.Error())
+			return
+		}
+		for i, point := range body {
+			p, err := point.point()
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Point %d: %v", i, err))
+				return
+			}
+			points = append(points, p)
+		}
+	}
+	if len(points) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "Route has no points")
+		return
+	}
+	if len(points) > maxBatchSize {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Route too long: %d points, limit is %d", len(points), maxBatchSize))
+		return
+	}
+
+	type routeZone struct {
+		Index   int    `json:"index"`
+		Id      string `json:"id,omitempty"`
+		Name    string `json:"name,omitempty"`
+		Outside bool   `json:"outside,omitempty"`
+	}
+	for i, point := range points {
+		if err := validateCoordinates(point.Lat, point.Lng); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Point %d: %v", i, err))
+			return
+		}
+	}
+
+	ctx, cancel := lookupContext(r)
+	defer cancel()
+	features, err := g.findFeatures(ctx, points)
+	if err != nil {
+		writeLookupError(w, err)
+		return
+	}
+	zones := []routeZone{}
+	for i, feature := range features {
+		if i > 0 && feature == features[i-1] {
+			continue
+		}
+		if feature == nil {
+			zones = append(zones, routeZone{Index: i, Outside: true})
+			continue
+		}
+		zones = append(zones, routeZone{Index: i, Id: feature.Properties.Id, Name: feature.Properties.Name})
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Status string      `json:"status"`
+		Zones  []routeZone `json:"zones"`
+	}{Status: "OK", Zones: zones})
+}
+
+// defaultSearchLimit and maxSearchLimit bound the number of /search results.
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 100
+)
+
+// searchHandler lists the features whose name contains ?q=, ignoring case,
+// located at their centroids and sorted by name, for autocomplete. At most
+// ?limit= results are returned.
+func (g *Geocoder) searchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !g.Available() {
+		writeUnavailable(w)
+		return
+	}
+
+	query := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	if query == "" {
+		writeJSONError(w, http.StatusBadRequest, "Missing q parameter")
+		return
+	}
+	limit := defaultSearchLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit parameter: must be between 1 and %d", maxSearchLimit))
+			return
+		}
+	}
+
+	var matches []*area
+	areas := g.currentAreas().areas
+	for i := range areas {
+		if strings.Contains(strings.ToLower(areas[i].Properties.Name), query) {
+			matches = append(matches, &areas[i])
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := strings.ToLower(matches[i].Properties.Name), strings.ToLower(matches[j].Properties.Name)
+		if a != b {
+			return a < b
+		}
+		return matches[i].Properties.Id < matches[j].Properties.Id
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	results := make([]Result, len(matches))
+	for i, feature := range matches {
+		results[i] = newResult(feature, feature.center.Lat, feature.center.Lng)
+		results[i].Geometry.LocationType = "GEOMETRIC_CENTER"
+		results[i].roundLocations(defaultDecimals)
+	}
+	status := "OK"
+	if len(results) == 0 {
+		status = "ZERO_RESULTS"
+	}
+	writeJSON(w, http.StatusOK, GeocodeResponse{Results: results, Status: status})
+}
+
+// forwardGeocode writes every feature whose name matches address, ignoring
+// case, located at the centroid of its polygon and rounded to decimals
+// places. It reports whether anything matched.
+func (g *Geocoder) forwardGeocode(w http.ResponseWriter, address string, decimals int) bool {
+	results := []Result{}
+	areas := g.currentAreas().areas
+	for i := range areas {
+		feature := &areas[i]
+		if !strings.EqualFold(feature.Properties.Name, address) {
+			continue
+		}
+		result := newResult(feature, feature.center.Lat, feature.center.Lng)
+		result.Geometry.LocationType = "GEOMETRIC_CENTER"
+		result.roundLocations(decimals)
+		results = append(results, result)
+	}
+
+	status := "OK"
+	if len(results) == 0 {
+		status = "ZERO_RESULTS"
+	}
+	writeJSON(w, http.StatusOK, GeocodeResponse{Results: results, Status: status})
+	return len(results) > 0
+}
+
+// sameAreaHandler reports whether the points (lat1, lng1) and (lat2, lng2)
+// resolve to the same area, and which one. Points outside every area are
+// never in the same area.
+func (g *Geocoder) sameAreaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !g.Available() {
+		writeUnavailable(w)
+		return
+	}
+
+	query := r.URL.Query()
+	lat1, lng1, err := pointFromQuery(query, "lat1", "lng1")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	lat2, lng2, err := pointFromQuery(query, "lat2", "lng2")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	type sameArea struct {
+		Same bool   `json:"same"`
+		Id   string `json:"id,omitempty"`
+	}
+	var response sameArea
+	first, second := g.findFeature(lng1, lat1), g.findFeature(lng2, lat2)
+	if first != nil && first == second {
+		response = sameArea{Same: true, Id: first.Properties.Id}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// maxCoverageSamples caps ?samples= on /coverage, giving at most
+// maxCoverageSamples² interior points.
+const maxCoverageSamples = 20
+
+// coverageHandler reports whether the bounding box minLat..maxLat,
+// minLng..maxLng lies within a single area. It tests the four corners and,
+// with ?samples=n, an n×n grid of interior points. A point outside every area
+// leaves the box uncovered.
+func (g *Geocoder) coverageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !g.Available() {
+		writeUnavailable(w)
+		return
+	}
+
+	query := r.URL.Query()
+	minLat, minLng, err := pointFromQuery(query, "minLat", "minLng")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	maxLat, maxLng, err := pointFromQuery(query, "maxLat", "maxLng")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if minLat > maxLat || minLng > maxLng {
+		writeJSONError(w, http.StatusBadRequest, "Invalid bounding box: min must not exceed max")
+		return
+	}
+	samples := 0
+	if value := query.Get("samples"); value != "" {
+		samples, err = strconv.Atoi(value)
+		if err != nil || samples < 0 || samples > maxCoverageSamples {
+			writeJSONError(w, http.StatusBadRequest, "Invalid samples parameter")
+			return
+		}
+	}
+
+	points := [][2]float64{{minLng, minLat}, {maxLng, minLat}, {maxLng, maxLat}, {minLng, maxLat}}
+	for i := 1; i <= samples; i++ {
+		for j := 1; j <= samples; j++ {
+			fi, fj := float64(i)/float64(samples+1), float64(j)/float64(samples+1)
+			points = append(points, [2]float64{minLng + fi*(maxLng-minLng), minLat + fj*(maxLat-minLat)})
+		}
+	}
+
+	type coverage struct {
+		Covered bool     `json:"covered"`
+		Id      string   `json:"id,omitempty"`
+		Zones   []string `json:"zones"`
+		Outside int      `json:"outside"`
+	}
+	response := coverage{Zones: []string{}}
+	seen := map[*area]bool{}
+	for _, point := range points {
+		feature := g.findFeature(point[0], point[1])
+		switch {
+		case feature == nil:
+			response.Outside++
+		case !seen[feature]:
+			seen[feature] = true
+			response.Zones = append(response.Zones, feature.Properties.Id)
+		}
+	}
+	if response.Outside == 0 && len(response.Zones) == 1 {
+		response.Covered, response.Id = true, response.Zones[0]
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// defaultNearestK and maxNearestK are the default and largest number of
+// zones /nearest returns.
+const (
+	defaultNearestK = 1
+	maxNearestK     = 50
+)
+
+// nearestHandler lists the ?k= zones whose boundaries are closest to the
+// point, nearest first, each with its distance_meters. Zones containing the
+// point are ranked by the distance to their boundary like any other.
+func (g *Geocoder) nearestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !g.Available() {
+		writeUnavailable(w)
+		return
+	}
+
+	query := r.URL.Query()
+	lat, lng, err := pointFromQuery(query, "lat", "lng")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	k := defaultNearestK
+	if value := query.Get("k"); value != "" {
+		k, err = strconv.Atoi(value)
+		if err != nil || k < 1 || k > maxNearestK {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid k parameter: must be between 1 and %d", maxNearestK))
+			return
+		}
+	}
+
+	nearest := g.findNearestFeatures(lng, lat, k)
+	results := make([]Result, len(nearest))
+	for i, n := range nearest {
+		distance := n.distance
+		results[i] = newResult(n.feature, lat, lng)
+		results[i].DistanceMeters = &distance
+		results[i].roundLocations(defaultDecimals)
+	}
+	status := "OK"
+	if len(results) == 0 {
+		status = "ZERO_RESULTS"
+	}
+	writeJSON(w, http.StatusOK, GeocodeResponse{Results: results, Status: status})
+}
+
+// defaultSnapRadius is the /snap search radius in meters when the request
+// does not give one.
+const defaultSnapRadius = 50.0
+
+// snapHandler snaps the point to the nearest polygon vertex within
+// ?radius= meters, reporting the vertex and the area it belongs to.
+func (g *Geocoder) snapHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !g.Available() {
+		writeUnavailable(w)
+		return
+	}
+
+	query := r.URL.Query()
+	lat, lng, err := pointFromQuery(query, "lat", "lng")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	radius := defaultSnapRadius
+	if value := query.Get("radius"); value != "" {
+		radius, err = strconv.ParseFloat(value, 64)
+		if err != nil || !isFinite(radius) || radius <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid radius parameter: must be a positive number of meters")
+			return
+		}
+	}
+
+	type snapResponse struct {
+		Status         string      `json:"status"`
+		Location       *Location   `json:"location,omitempty"`
+		DistanceMeters *float64    `json:"distance_meters,omitempty"`
+		Feature        *Properties `json:"feature,omitempty"`
+	}
+	vertex, feature, ok := g.nearestVertex(lng, lat, radius)
+	if !ok {
+		writeJSON(w, http.StatusOK, snapResponse{Status: "ZERO_RESULTS"})
+		return
+	}
+	distance := haversineMeters(lng, lat, vertex.Lng, vertex.Lat)
+	writeJSON(w, http.StatusOK, snapResponse{
+		Status:         "OK",
+		Location:       &Location{Lat: vertex.Lat, Lng: vertex.Lng},
+		DistanceMeters: &distance,
+		Feature:        &Properties{Name: feature.Properties.Name, Id: feature.Properties.Id},
+	})
+}
+
+// defaultPOIRadius is the /nearestPOI search radius in meters when the
+// request does not give one.
+const defaultPOIRadius = 1000.0
+
+// nearestPOIHandler returns the point-of-interest feature nearest to the
+// point within ?radius= meters, with all of its properties.
+func (g *Geocoder) nearestPOIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !g.Available() {
+		writeUnavailable(w)
+		return
+	}
+
+	query := r.URL.Query()
+	lat, lng, err := pointFromQuery(query, "lat", "lng")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	radius := defaultPOIRadius
+	if value := query.Get("radius"); value != "" {
+		radius, err = strconv.ParseFloat(value, 64)
+		if err != nil || !isFinite(radius) || radius <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid radius parameter: must be a positive number of meters")
+			return
+		}
+	}
+
+	type poiResponse struct {
+		Status         string      `json:"status"`
+		Location       *Location   `json:"location,omitempty"`
+		DistanceMeters *float64    `json:"distance_meters,omitempty"`
+		Feature        *Properties `json:"feature,omitempty"`
+	}
+	poi, distance, ok := g.nearestPOI(lng, lat, radius)
+	if !ok {
+		writeJSON(w, http.StatusOK, poiResponse{Status: "ZERO_RESULTS"})
+		return
+	}
+	writeJSON(w, http.StatusOK, poiResponse{
+		Status:         "OK",
+		Location:       &Location{Lat: poi.Geometry.Point.Lat, Lng: poi.Geometry.Point.Lng},
+		DistanceMeters: &distance,
+		Feature:        &poi.Properties,
+	})
+}
+
+// areaSummary is one entry of the /areas listing. Bounds is the GeoJSON
+// bbox [west, south, east, north]; west is greater than east for areas that
+// cross the antimeridian.
+type areaSummary struct {
+	Id     string      `json:"id"`
+	Name   string      `json:"name"`
+	Bounds *[4]float64 `json:"bounds,omitempty"`
+
+	// AreaKm2 and PerimeterKm are the area's size and total boundary
+	// length, holes included, when requested with ?metrics=true.
+	AreaKm2     *float64 `json:"area_km2,omitempty"`
+	PerimeterKm *float64 `json:"perimeter_km,omitempty"`
+}
+
+// areasHandler lists the id and name of every loaded area, in file order.
+// With ?withBounds=true each entry also carries its bounding box, and with
+// ?metrics=true its area and perimeter.
+func (g *Geocoder) areasHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	set := g.currentAreas()
+	if set == nil {
+		writeUnavailable(w)
+		return
+	}
+
+	withBounds := r.URL.Query().Get("withBounds") == "true"
+	withMetrics := r.URL.Query().Get("metrics") == "true"
+	summaries := make([]areaSummary, len(set.areas))
+	for i := range set.areas {
+		feature := &set.areas[i]
+		summaries[i] = areaSummary{Id: feature.Properties.Id, Name: feature.Properties.Name}
+		if withBounds {
+			b := feature.bounds
+			bounds := [4]float64{wrapLng(b.MinLng), b.MinLat, wrapLng(b.MaxLng), b.MaxLat}
+			summaries[i].Bounds = &bounds
+		}
+		if withMetrics {
+			areaKm2, perimeterKm := feature.areaKm2, feature.perimeterKm
+			summaries[i].AreaKm2, summaries[i].PerimeterKm = &areaKm2, &perimeterKm
+		}
+	}
+
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+// areasGeoJSONHandler returns the loaded FeatureCollection as GeoJSON, with
+// coordinates exactly as they were read. With ?id= it returns just the
+// feature with that id, or 404 if there is none. Responses carry the ETag of
+// the loaded collection and are 304 Not Modified when it still matches.
+func (g *Geocoder) areasGeoJSONHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	set := g.currentAreas()
+	if set == nil {
+		writeUnavailable(w)
+		return
+	}
+
+	if set.etag != "" {
+		w.Header().Set("ETag", set.etag)
+		if etagMatches(r.Header.Get("If-None-Match"), set.etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeGeoJSON(w, http.StatusOK, set.collection)
+		return
+	}
+	for _, feature := range set.collection.Features {
+		if feature.Properties.Id == id {
+			writeGeoJSON(w, http.StatusOK, feature)
+			return
+		}
+	}
+	writeJSONError(w, http.StatusNotFound, fmt.Sprintf("No area with id %q", id))
+}
+
+// geocodeMaxAge is the max-age of the Cache-Control header on GET reverse
+// geocode responses; 0 leaves responses uncacheable.
+var geocodeMaxAge = 5 * time.Minute
+
+// notModified sets the caching headers of a GET reverse geocode that
+// resolved to features, and answers 304 and reports true if the client's
+// copy is still current. A response only changes when the areas are
+// reloaded with different contents or the point resolves differently, so
+// the ETag hashes the collection version, the fallback locality and the ids
+// of the matched features.
+func notModified(w http.ResponseWriter, r *http.Request, version string, features []*area) bool {
+	if geocodeMaxAge <= 0 || version == "" {
+		return false
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s", version, fallbackLocality)
+	for _, feature := range features {
+		fmt.Fprintf(h, "\x00%s", feature.Properties.Id)
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(geocodeMaxAge.Seconds())))
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag,
+// using the weak comparison RFC 9110 requires for If-None-Match.
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultDebugLimit and maxDebugLimit bound the number of features listed
+// by one /debug/point response.
+const (
+	defaultDebugLimit = 100
+	maxDebugLimit     = 1000
+)
+
+// debugPointHandler explains how ?lat= and ?lng= resolve: for each feature,
+// in file order, whether its bounding box holds the point, whether the
+// point-in-polygon test puts it inside and how long that test took. Features
+// are paged with ?offset= and ?limit=; the match is reported whatever the
+// page. It is a debugging aid, not part of the geocoding API.
+func (g *Geocoder) debugPointHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !g.Available() {
+		writeUnavailable(w)
+		return
+	}
+
+	query := r.URL.Query()
+	lat, lng, err := pointFromQuery(query, "lat", "lng")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, limit := 0, defaultDebugLimit
+	if value := query.Get("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid offset parameter")
+			return
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxDebugLimit {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit parameter: must be between 1 and %d", maxDebugLimit))
+			return
+		}
+	}
+
+	type featureTest struct {
+		Index   int     `json:"index"`
+		Id      string  `json:"id"`
+		Name    string  `json:"name"`
+		InBBox  bool    `json:"in_bbox"`
+		Inside  bool    `json:"inside"`
+		TestUs  float64 `json:"test_us"`
+		Polygon *int    `json:"polygon,omitempty"`
+	}
+	type debugPoint struct {
+		Location   Location      `json:"location"`
+		Features   int           `json:"features"`
+		Candidates int           `json:"candidates"`
+		Match      string        `json:"match,omitempty"`
+		LookupUs   float64       `json:"lookup_us"`
+		Offset     int           `json:"offset"`
+		Limit      int           `json:"limit"`
+		Tests      []featureTest `json:"tests"`
+	}
+
+	set := g.currentAreas()
+	response := debugPoint{
+		Location: Location{Lat: lat, Lng: lng},
+		Features: len(set.areas),
+		Offset:   offset,
+		Limit:    limit,
+		Tests:    []featureTest{},
+	}
+	start := time.Now()
+	match := lookupFeature(set, lng, lat)
+	response.LookupUs = microseconds(time.Since(start))
+	response.Candidates = len(set.candidates(lng, lat))
+	if match != nil {
+		response.Match = match.Properties.Id
+	}
+
+	for i := offset; i < len(set.areas) && i < offset+limit; i++ {
+		feature := &set.areas[i]
+		test := featureTest{
+			Index:  i,
+			Id:     feature.Properties.Id,
+			Name:   feature.Properties.Name,
+			InBBox: feature.bounds.contains(feature.queryLng(lng), lat),
+		}
+		start := time.Now()
+		polygon := feature.locate(lng, lat)
+		test.TestUs = microseconds(time.Since(start))
+		if polygon >= 0 {
+			test.Inside, test.Polygon = true, &polygon
+		}
+		response.Tests = append(response.Tests, test)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func microseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Microsecond)
+}
+
+// healthResponse is the body of /healthz.
+type healthResponse struct {
+	Status   string `json:"status"`
+	Features int    `json:"features"`
+}
+
+// healthzHandler reports whether an areas file has been loaded, without
+// touching the file itself. A loaded file with no usable features is
+// reported as degraded, so that an empty deploy fails health checks.
+func (g *Geocoder) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	set := g.currentAreas()
+	if set == nil {
+		writeJSON(w, http.StatusServiceUnavailable, healthResponse{Status: "unavailable"})
+		return
+	}
+	if len(set.areas) == 0 {
+		writeJSON(w, http.StatusServiceUnavailable, healthResponse{Status: "degraded"})
+		return
+	}
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok", Features: len(set.areas)})
+}
+
+func (g *Geocoder) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	features, err := g.Reload()
+	if err != nil {
+		slog.Error("Error reloading areas", "request_id", requestIDFromContext(r.Context()), "err", err)
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	slog.Info("Reloaded areas", "request_id", requestIDFromContext(r.Context()), "features", features, "path", g.path)
+
+	writeJSON(w, http.StatusOK, struct {
+		Status   string `json:"status"`
+		Features int    `json:"features"`
+	}{Status: "OK", Features: features})
+}
@@ -0,0 +1,102 @@
+package main
+
+import "math"
+
+// gridCellSize is the edge length, in degrees, of a SpatialIndex cell.
+// Roughly 1.1 km at the equator, which is about the size of a delivery zone.
+const gridCellSize = 0.01
+
+type gridCell struct {
+	x, y int
+}
+
+// maxCellsPerArea caps the cells a single area is registered in. Beyond it,
+// about a 0.64° square at gridCellSize, the area goes on a list that every
+// lookup scans instead, so that a province, a continent or a MultiPolygon
+// spanning the antimeridian does not add millions of cells.
+const maxCellsPerArea = 4096
+
+// SpatialIndex is a uniform grid over feature bounding boxes. Each feature is
+// registered in every cell its box overlaps, so a lookup only has to look at
+// the features listed under a single cell and at the few features too large
+// to register cell by cell.
+type SpatialIndex struct {
+	cellSize float64
+	areas    []*area
+	cells    map[gridCell][]int
+	// large lists, in insertion order, the areas that cover more than
+	// maxCellsPerArea cells.
+	large []int
+}
+
+func NewSpatialIndex(cellSize float64) *SpatialIndex {
+	return &SpatialIndex{
+		cellSize: cellSize,
+		cells:    make(map[gridCell][]int),
+	}
+}
+
+// Insert adds feature to the index.
+func (idx *SpatialIndex) Insert(feature Feature) {
+	a := newArea(feature)
+	idx.insert(&a)
+}
+
+func (idx *SpatialIndex) insert(a *area) {
+	i := len(idx.areas)
+	idx.areas = append(idx.areas, a)
+
+	b := a.bounds
+	if b.MinLng > b.MaxLng || b.MinLat > b.MaxLat {
+		return
+	}
+	lo, hi := idx.cell(b.MinLng, b.MinLat), idx.cell(b.MaxLng, b.MaxLat)
+	if (hi.x-lo.x+1)*(hi.y-lo.y+1) > maxCellsPerArea {
+		idx.large = append(idx.large, i)
+		return
+	}
+	for x := lo.x; x <= hi.x; x++ {
+		for y := lo.y; y <= hi.y; y++ {
+			c := gridCell{x, y}
+			idx.cells[c] = append(idx.cells[c], i)
+		}
+	}
+}
+
+func (idx *SpatialIndex) cell(lng float64, lat float64) gridCell {
+	return gridCell{
+		x: int(math.Floor(lng / idx.cellSize)),
+		y: int(math.Floor(lat / idx.cellSize)),
+	}
+}
+
+// candidates returns the areas whose bounding box contains the point, in
+// insertion order.
+func (idx *SpatialIndex) candidates(lng float64, lat float64) []*area {
+	var result []*area
+	cell, large := idx.cells[idx.cell(lng, lat)], idx.large
+	// Both lists are in insertion order; merge them to keep it.
+	for len(cell) > 0 || len(large) > 0 {
+		var i int
+		if len(large) == 0 || len(cell) > 0 && cell[0] < large[0] {
+			i, cell = cell[0], cell[1:]
+		} else {
+			i, large = large[0], large[1:]
+		}
+		if a := idx.areas[i]; a.bounds.contains(lng, lat) {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+// Query returns the features whose bounding box contains the point. The
+// features still have to be tested against their actual polygons.
+func (idx *SpatialIndex) Query(lng float64, lat float64) []Feature {
+	candidates := idx.candidates(lng, lat)
+	features := make([]Feature, len(candidates))
+	for i, a := range candidates {
+		features[i] = a.Feature
+	}
+	return features
+}
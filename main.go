@@ -1,197 +1,317 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"math"
+	"log/slog"
+	"net"
 	"net/http"
-	"strconv"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/crypto/acme/autocert"
 )
 
-type Point struct {
-	Lng float64
-	Lat float64
-}
+const defaultAreasFile = "areas.json"
 
-type Polygon struct {
-	Coordinates [][][]float64 `json:"coordinates"`
-	Type        string        `json:"type"`
-}
+func main() {
+	httpAddr := flag.String("http-addr", "127.0.0.1:8080", `comma-separated addresses for the plain HTTP server; the default is IPv4 loopback only, "127.0.0.1:8080,[::1]:8080" adds IPv6 loopback and ":8080" listens on every interface, dual-stack`)
+	httpsAddr := flag.String("https-addr", ":8443", `address for the HTTPS server; the default ":8443" listens on every interface, dual-stack, and "[::1]:8443" or "127.0.0.1:8443" on one loopback`)
+	certFile := flag.String("cert", "/etc/letsencrypt/live/alpha.bludelivery.et/fullchain.pem", "TLS certificate file; leave empty to disable HTTPS")
+	keyFile := flag.String("key", "/etc/letsencrypt/live/alpha.bludelivery.et/privkey.pem", "TLS private key file; leave empty to disable HTTPS")
+	autocertDomains := flag.String("autocert-domain", "", "comma-separated domains to obtain certificates for from Let's Encrypt, replacing -cert/-key; the TLS-ALPN challenge needs -https-addr on port 443")
+	autocertCache := flag.String("autocert-cache", "autocert-cache", "directory where -autocert-domain certificates are cached")
+	flag.IntVar(&maxBatchSize, "batch-limit", maxBatchSize, "maximum number of points accepted by /geocode/batch")
+	flag.IntVar(&batchWorkers, "batch-workers", batchWorkers, "number of goroutines that share the lookups of a /geocode/batch or /route request")
+	flag.Int64Var(&maxBodyBytes, "max-body-bytes", maxBodyBytes, "maximum size in bytes of a POST request body; larger bodies get 413")
+	flag.Float64Var(&boundaryEpsilonMeters, "boundary-epsilon", boundaryEpsilonMeters, "distance in meters from an edge within which ?classify=true reports a point as on the boundary")
+	pipAlgorithm := flag.String("pip-algorithm", "ray", "point-in-polygon algorithm: ray (ray casting, the even-odd rule) or winding (winding number, the nonzero rule); ?fillRule= overrides it per request")
+	flag.Float64Var(&simplifyTolerance, "simplify-tolerance", 0, "Douglas-Peucker tolerance in degrees for the polygons used in containment tests; 0 keeps every vertex")
+	flag.StringVar(&fallbackLocality, "fallback-name", fallbackLocality, "locality returned for points outside every area; empty returns ZERO_RESULTS instead")
+	flag.BoolVar(&strictMode, "strict", strictMode, "return ZERO_RESULTS instead of the fallback locality for points outside every area, as ?strict=true does per request")
+	fallbackTypeList := flag.String("fallback-types", strings.Join(fallbackTypes, ","), "comma-separated types of the fallback locality result")
+	origins := flag.String("allowed-origins", "", `comma-separated origins allowed by CORS; "*" allows any origin`)
+	readTimeout := flag.Duration("read-timeout", 10*time.Second, "maximum duration for reading an entire request")
+	writeTimeout := flag.Duration("write-timeout", 30*time.Second, "maximum duration before timing out writes of a response")
+	idleTimeout := flag.Duration("idle-timeout", 120*time.Second, "maximum time to wait for the next request on a keep-alive connection")
+	flag.DurationVar(&lookupTimeout, "lookup-timeout", lookupTimeout, "maximum time the area lookups of one request may take before it fails with 504; 0 disables the limit")
+	flag.DurationVar(&geocodeMaxAge, "geocode-max-age", geocodeMaxAge, "Cache-Control max-age of GET /geocode responses, which also carry an ETag; 0 disables both")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for in-flight requests on shutdown")
+	cacheSize := flag.Int("cache-size", defaultCacheSize, "number of recent lookups to cache; 0 disables the cache")
+	logLevel := flag.String("log-level", "info", "log level: debug, info, warn or error")
+	rateLimit := flag.Float64("rate-limit", 10, "requests per second allowed per client IP; 0 disables rate limiting")
+	rateBurst := flag.Int("rate-burst", 20, "number of requests a client IP may make in a burst above -rate-limit")
+	trustForwardedFor := flag.Bool("trust-forwarded-for", false, "rate limit by the X-Forwarded-For client address; enable only behind a proxy that sets it")
+	readOnly := flag.Bool("readonly", false, "answer the mutating endpoints (POST /reload) with 403, leaving lookups, health and area listings available")
+	watch := flag.Bool("watch", false, "reload the areas automatically when they change on disk")
+	validate := flag.Bool("validate", false, "check the areas file, print a report and exit without starting the servers")
+	areasPath := flag.String("areas", envOr("GEOMOCKER_AREAS", defaultAreasFile), "path to the areas GeoJSON file, or a directory of them (env GEOMOCKER_AREAS); empty serves only -regions")
+	regionList := flag.String("regions", "", "comma-separated name=path datasets, each served with its own endpoints under /region/<name>/")
+	configFile := flag.String("config", "", "JSON file of settings keyed by flag name; flags given on the command line override it")
+	flag.Parse()
+	if *configFile != "" {
+		explicit := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+		config, err := loadConfig(*configFile)
+		if err == nil {
+			err = config.apply(flag.CommandLine, explicit)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -config: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	allowedOrigins = parseOrigins(*origins)
+	fallbackTypes = parseList(*fallbackTypeList)
 
-type Feature struct {
-	Properties struct {
-		Name string `json:"name"`
-		Id   string `json:"id"`
-	} `json:"properties"`
-	Geometry Polygon `json:"geometry"`
-	Type     string  `json:"type"`
-}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -log-level %q: %v\n", *logLevel, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	slog.Info("Effective settings", effectiveSettings(flag.CommandLine)...)
 
-type FeatureCollection struct {
-	Features []Feature `json:"features"`
-	Type     string    `json:"type"`
-}
+	algorithm, err := parsePointInPolygon(*pipAlgorithm)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -pip-algorithm: %v\n", err)
+		os.Exit(2)
+	}
+	pointInPolygon = algorithm
 
-func main() {
-	handler := http.HandlerFunc(geocodeHandler)
-
-	// Start HTTP server (localhost only) in a goroutine
-	go func() {
-		fmt.Println("HTTP Server listening on :8080 (localhost only)")
-		localServer := &http.Server{
-			Addr:    "127.0.0.1:8080", // Only accessible from localhost
-			Handler: handler,
+	regions, err := parseRegions(*regionList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -regions: %v\n", err)
+		os.Exit(2)
+	}
+	if *areasPath == "" && len(regions) == 0 {
+		fmt.Fprintln(os.Stderr, "invalid -areas: empty, and no -regions to serve instead")
+		os.Exit(2)
+	}
+
+	// datasets maps each path prefix to the areas file served under it.
+	datasets := map[string]string{}
+	if *areasPath != "" {
+		datasets[""] = *areasPath
+	}
+	for name, path := range regions {
+		datasets[regionPrefix+name] = path
+	}
+	for prefix, path := range datasets {
+		areasFile, err := filepath.Abs(path)
+		if err != nil {
+			fatal("Error resolving areas path", "prefix", prefix, "path", path, "err", err)
+		}
+		if _, err := os.Stat(areasFile); err != nil {
+			fatal("Areas file not found", "prefix", prefix, "err", err)
+		}
+		datasets[prefix] = areasFile
+	}
+
+	if *validate {
+		status := 0
+		for _, areasFile := range datasets {
+			status = max(status, validateAreasFile(areasFile))
+		}
+		os.Exit(status)
+	}
+
+	handler := http.NewServeMux()
+	handler.Handle("/metrics", promhttp.Handler())
+	handler.HandleFunc("/openapi.json", openapiHandler)
+	// Without a default dataset the map page is still served, and geocode
+	// queries sent to / find no data.
+	root := &Geocoder{}
+	for prefix, areasFile := range datasets {
+		geocoder, err := NewGeocoderFromFile(areasFile)
+		if err != nil {
+			fatal("Error loading areas", "prefix", prefix, "err", err)
+		}
+		geocoder.SetCacheSize(*cacheSize)
+		slog.Info("Loaded areas", "prefix", prefix, "features", len(geocoder.currentAreas().areas), "path", areasFile)
+		if *watch {
+			if err := geocoder.Watch(); err != nil {
+				fatal("Error watching areas", "prefix", prefix, "err", err)
+			}
+		}
+		if prefix == "" {
+			root = geocoder
+		}
+		registerDataset(handler, prefix, geocoder, *readOnly)
+	}
+	handler.HandleFunc("/", root.rootHandler)
+
+	middleware := []Middleware{requestID, logRequests, recoverPanics}
+	if *rateLimit > 0 {
+		if *rateBurst < 1 {
+			fatal("Invalid -rate-burst: must be at least 1", "rate-burst", *rateBurst)
+		}
+		limiter := newIPRateLimiter(*rateLimit, *rateBurst, *trustForwardedFor)
+		middleware = append(middleware, limiter.middleware)
+	}
+	middleware = append(middleware, gzipResponses, cors, jsonp)
+
+	newServer := func(addr string) *http.Server {
+		return &http.Server{
+			Addr:              addr,
+			Handler:           chain(handler, middleware...),
+			ReadHeaderTimeout: *readTimeout,
+			ReadTimeout:       *readTimeout,
+			WriteTimeout:      *writeTimeout,
+			IdleTimeout:       *idleTimeout,
 		}
-		if err := localServer.ListenAndServe(); err != nil {
-			log.Printf("HTTP server error: %v", err)
+	}
+
+	var servers []*http.Server
+	serverErrors := make(chan error, 1)
+	// serve starts the server on a listener bound up front, so that a bad
+	// or busy address fails startup and the resolved address can be logged.
+	serve := func(msg string, server *http.Server, run func(net.Listener) error, args ...any) {
+		listener, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			shutdownServers(servers, *shutdownTimeout)
+			fatal("Error listening", "addr", server.Addr, "err", err)
 		}
-	}()
+		servers = append(servers, server)
+		slog.Info(msg, append([]any{"addr", server.Addr, "listen", listener.Addr().String()}, args...)...)
+		go func() {
+			if err := run(listener); err != http.ErrServerClosed {
+				select {
+				case serverErrors <- fmt.Errorf("%s: %w", server.Addr, err):
+				default:
+				}
+			}
+		}()
+	}
+
+	// Start HTTP servers
+	for _, addr := range parseList(*httpAddr) {
+		server := newServer(addr)
+		serve("HTTP Server listening", server, server.Serve)
+	}
 
 	// Start HTTPS server
-	fmt.Println("HTTPS Server listening on :8443")
-	err := http.ListenAndServeTLS(":8443",
-		"/etc/letsencrypt/live/alpha.bludelivery.et/fullchain.pem",
-		"/etc/letsencrypt/live/alpha.bludelivery.et/privkey.pem",
-		handler)
-	if err != nil {
-		log.Fatal("ListenAndServeTLS: ", err)
+	switch {
+	case *autocertDomains != "":
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(parseList(*autocertDomains)...),
+			Cache:      autocert.DirCache(*autocertCache),
+		}
+		tlsServer := newServer(*httpsAddr)
+		tlsServer.TLSConfig = manager.TLSConfig()
+		serve("HTTPS Server listening with autocert", tlsServer, func(listener net.Listener) error {
+			return tlsServer.ServeTLS(listener, "", "")
+		}, "domains", *autocertDomains, "cache", *autocertCache)
+	case *certFile == "" || *keyFile == "":
+		slog.Info("HTTPS Server disabled (no -cert/-key)")
+	default:
+		tlsServer := newServer(*httpsAddr)
+		serve("HTTPS Server listening", tlsServer, func(listener net.Listener) error {
+			return tlsServer.ServeTLS(listener, *certFile, *keyFile)
+		})
+	}
+	if len(servers) == 0 {
+		fatal("No servers to start: -http-addr is empty and HTTPS is disabled")
 	}
-}
 
-func geocodeHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
-	if r.Method == "OPTIONS" {
-		return
+	select {
+	case sig := <-stop:
+		slog.Info("Shutting down", "signal", sig)
+		if err := shutdownServers(servers, *shutdownTimeout); err != nil {
+			fatal("Shutdown failed", "err", err)
+		}
+	case err := <-serverErrors:
+		shutdownServers(servers, *shutdownTimeout)
+		fatal("Server error", "err", err)
 	}
-	latStr := r.URL.Query().Get("lat")
-	lngStr := r.URL.Query().Get("lng")
+}
+
+// shutdownServers stops every server from accepting new connections and
+// waits up to timeout for in-flight requests to finish.
+func shutdownServers(servers []*http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	if latStr == "" || lngStr == "" {
-		http.Error(w, "Missing lat or lng parameters", http.StatusBadRequest)
-		return
+	var wg sync.WaitGroup
+	errs := make([]error, len(servers))
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server *http.Server) {
+			defer wg.Done()
+			slog.Info("Draining connections", "addr", server.Addr)
+			errs[i] = server.Shutdown(ctx)
+		}(i, server)
 	}
+	wg.Wait()
 
-	lat, err := strconv.ParseFloat(latStr, 64)
-	if err != nil {
-		http.Error(w, "Invalid lat parameter", http.StatusBadRequest)
-		return
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
 	}
+	slog.Info("All servers stopped")
+	return nil
+}
 
-	lng, err := strconv.ParseFloat(lngStr, 64)
-	if err != nil {
-		http.Error(w, "Invalid lng parameter", http.StatusBadRequest)
-		return
-	}
-
-	areaName, areaId := findArea(lng, lat)
-
-	if areaName == "" {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(fmt.Sprintf(`{
-                        "results": [
-                                {
-                                        "address_components": [
-                                                {
-                                                        "long_name": "Dire Dawa",
-                                                        "short_name": "Dire Dawa",
-                                                        "types": ["locality", "political"]
-                                                }
-                                        ],
-                                        "formatted_address": "Dire Dawa",
-                                        "geometry": {
-                                                "location": {
-                                                        "lat": %f,
-                                                        "lng": %f
-                                                },
-                                                "location_type": "APPROXIMATE"
-                                        },
-                                        "place_id": "unknown",
-                                        "types": ["locality", "political"]
-                                }
-                        ],
-                        "status": "OK"
-                }`, lat, lng)))
-		return
-	}
-
-	response := fmt.Sprintf(`{
-                "results": [
-                        {
-                                "address_components": [
-                                        {
-                                                "long_name": "%s, Dire Dawa",
-                                                "short_name": "%s",
-                                                "types": ["locality", "political"]
-                                        }
-                                ],
-                                "formatted_address": "%s",
-                                "geometry": {
-                                        "location": {
-                                                "lat": %f,
-                                                "lng": %f
-                                        },
-                                        "location_type": "APPROXIMATE"
-                                },
-                                "place_id": "%s",
-                                "types": ["locality", "political"]
-                        }
-                ],
-                "status": "OK"
-        }`, areaName, areaName, areaName, lat, lng, areaId)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(response))
+// readOnlyHandler refuses a mutating endpoint under -readonly.
+func readOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, http.StatusForbidden, "Server is read-only")
 }
 
-func findArea(lng float64, lat float64) (string, string) {
-	data, err := ioutil.ReadFile("areas.json")
+// validateAreasFile prints a validation report for the areas at path and
+// returns the process exit code: 0 if there were no problems, 1 otherwise.
+func validateAreasFile(path string) int {
+	featureCollection, err := readAreas(path)
 	if err != nil {
-		log.Println("Error reading areas.json:", err)
-		return "", ""
+		fmt.Fprintln(os.Stderr, err)
+		return 1
 	}
 
-	var featureCollection FeatureCollection
-	err = json.Unmarshal(data, &featureCollection)
-	if err != nil {
-		log.Println("Error unmarshalling JSON:", err)
-		return "", ""
-	}
-	log.Printf("Number of features: %d", len(featureCollection.Features))
-	for _, feature := range featureCollection.Features {
-		// Corrected call: pass feature.Geometry.Coordinates[0][0]
-		if isPointInPolygon(lng, lat, feature.Geometry.Coordinates[0]) {
-			return feature.Properties.Name, feature.Properties.Id
+	problems := validateAreas(featureCollection.Features)
+	if len(problems) == 0 {
+		fmt.Printf("%s: %d features, OK\n", path, len(featureCollection.Features))
+		return 0
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	fmt.Printf("%s: %d features, %d problems\n", path, len(featureCollection.Features), len(problems))
+	return 1
+}
+
+// parseList splits a comma-separated flag value, dropping empty entries.
+func parseList(list string) []string {
+	values := []string{}
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
 		}
 	}
+	return values
+}
 
-	return "", ""
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
-// Corrected function parameter type.
-func isPointInPolygon(lng float64, lat float64, polygon [][]float64) bool {
-	n := len(polygon)
-	inside := false
-	p1x, p1y := polygon[0][0], polygon[0][1]
-
-	for i := 0; i < n+1; i++ {
-		p2x, p2y := polygon[i%n][0], polygon[i%n][1]
-		if lat > math.Min(p1y, p2y) {
-			if lat <= math.Max(p1y, p2y) {
-				if lng <= math.Max(p1x, p2x) {
-					if p1y != p2y {
-						xinters := (lat-p1y)*(p2x-p1x)/(p2y-p1y) + p1x
-						if p1x == p2x || lng <= xinters {
-							inside = !inside
-						}
-					}
-				}
-			}
-		}
-		p1x, p1y = p2x, p2y
+// envOr returns the value of the environment variable key, or fallback if
+// it is unset or empty.
+func envOr(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
 	}
-	return inside
+	return fallback
 }
@@ -0,0 +1,1893 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	unitSquare = [][]float64{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}
+
+	// lShape is the unit square with its top-right quarter cut away.
+	lShape = [][]float64{{0, 0}, {1, 0}, {1, 0.5}, {0.5, 0.5}, {0.5, 1}, {0, 1}, {0, 0}}
+)
+
+func TestIsPointInPolygon(t *testing.T) {
+	tests := []struct {
+		name     string
+		ring     [][]float64
+		lng, lat float64
+		want     bool
+	}{
+		{"square center", unitSquare, 0.5, 0.5, true},
+		{"square outside", unitSquare, 2, 2, false},
+		{"square outside left", unitSquare, -0.5, 0.5, false},
+		{"square just inside east edge", unitSquare, 1 - 1e-9, 0.5, true},
+		{"square just outside east edge", unitSquare, 1 + 1e-9, 0.5, false},
+		{"square just inside north edge", unitSquare, 0.5, 1 - 1e-9, true},
+		{"square just outside south edge", unitSquare, 0.5, -1e-9, false},
+		{"square on west edge", unitSquare, 0, 0.5, true},
+		{"square on south edge", unitSquare, 0.5, 0, true},
+		{"square on east edge", unitSquare, 1, 0.5, false},
+		{"square on north edge", unitSquare, 0.5, 1, false},
+		{"square on south-west vertex", unitSquare, 0, 0, true},
+		{"square on north-east vertex", unitSquare, 1, 1, false},
+		{"L lower arm", lShape, 0.75, 0.25, true},
+		{"L upper arm", lShape, 0.25, 0.75, true},
+		{"L notch", lShape, 0.75, 0.75, false},
+		{"L level with inner vertex", lShape, 0.25, 0.5, true},
+		{"empty ring", nil, 0, 0, false},
+		{"two point ring", [][]float64{{0, 0}, {1, 1}}, 0.5, 0.5, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPointInPolygon(tt.lng, tt.lat, tt.ring); got != tt.want {
+				t.Errorf("isPointInPolygon(%v, %v) = %v, want %v", tt.lng, tt.lat, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPointInPolygonWindingOrder(t *testing.T) {
+	points := [][2]float64{
+		{0.5, 0.5}, {0.75, 0.25}, {0.25, 0.75}, {0.75, 0.75}, {2, 2},
+		{0, 0.5}, {0.5, 0}, {1, 0.25}, {0.5, 1}, {0.5, 0.5 + 1e-12},
+	}
+
+	for _, ring := range [][][]float64{unitSquare, lShape} {
+		clockwise := reverseRing(ring)
+		for _, p := range points {
+			ccw := isPointInPolygon(p[0], p[1], ring)
+			cw := isPointInPolygon(p[0], p[1], clockwise)
+			if ccw != cw {
+				t.Errorf("point %v: counter-clockwise = %v, clockwise = %v", p, ccw, cw)
+			}
+		}
+	}
+}
+
+func TestUnclosedRingMatchesClosedRing(t *testing.T) {
+	for _, closed := range [][][]float64{unitSquare, lShape} {
+		unclosed := closed[:len(closed)-1]
+		if got := closeRing(unclosed); len(got) != len(closed) {
+			t.Fatalf("closeRing added %d positions, want 1", len(got)-len(unclosed))
+		}
+
+		feature := Feature{Geometry: Geometry{Polygons: []Polygon{{unclosed}}}}
+		a := newArea(feature)
+		for lng := -0.25; lng <= 1.25; lng += 0.125 {
+			for lat := -0.25; lat <= 1.25; lat += 0.125 {
+				want := isPointInPolygon(lng, lat, closed)
+				if got := isPointInPolygon(lng, lat, closeRing(unclosed)); got != want {
+					t.Errorf("(%v, %v): closed ring = %v, auto-closed ring = %v", lng, lat, want, got)
+				}
+				if got := a.contains(lng, lat); got != want {
+					t.Errorf("(%v, %v): closed ring = %v, loaded unclosed ring = %v", lng, lat, want, got)
+				}
+			}
+		}
+	}
+}
+
+func TestConcurrentLookupsDuringReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "areas.json")
+	data := `{"type":"FeatureCollection","features":[{"type":"Feature",
+		"properties":{"name":"Square","id":"square"},
+		"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}}]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewGeocoderFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				name, _, ok, err := g.Lookup(0.5, 0.5)
+				if err != nil || !ok || name != "Square" {
+					t.Errorf("Lookup = %q, %v, %v; want Square", name, ok, err)
+					return
+				}
+				g.findAllFeatures(0.5, 0.5)
+				g.findNearestFeature(2, 2)
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		if _, err := g.Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestGeohashEncode(t *testing.T) {
+	tests := []struct {
+		lat, lng  float64
+		precision int
+		want      string
+	}{
+		{42.6, -5.6, 5, "ezs42"},
+		{57.64911, 10.40744, 11, "u4pruydqqvj"},
+		{0, 0, 1, "s"},
+		{-90, -180, 5, "00000"},
+		{90, 180, 5, "zzzzz"},
+	}
+	for _, tt := range tests {
+		if got := geohashEncode(tt.lat, tt.lng, tt.precision); got != tt.want {
+			t.Errorf("geohashEncode(%v, %v, %d) = %q, want %q", tt.lat, tt.lng, tt.precision, got, tt.want)
+		}
+	}
+}
+
+// denseRing returns a closed, slightly wobbly ring around (0, 0) with n
+// vertices, like a densely sampled surveyed boundary.
+func denseRing(n int) [][]float64 {
+	ring := make([][]float64, 0, n+1)
+	for i := 0; i < n; i++ {
+		angle := 2 * math.Pi * float64(i) / float64(n)
+		r := 0.01 * (1 + 0.05*math.Sin(7*angle) + 0.0005*math.Sin(301*angle))
+		ring = append(ring, []float64{r * math.Cos(angle), r * math.Sin(angle)})
+	}
+	return append(ring, ring[0])
+}
+
+func TestSimplifiedContainmentStaysNearEdges(t *testing.T) {
+	const tolerance = 1e-5
+	ring := denseRing(5000)
+	simplified := simplifyRing(ring, tolerance)
+	if len(simplified) >= len(ring)/2 {
+		t.Fatalf("simplifyRing kept %d of %d positions", len(simplified), len(ring))
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		lng, lat := (rng.Float64()-0.5)*0.025, (rng.Float64()-0.5)*0.025
+		if isPointInPolygon(lng, lat, ring) == isPointInPolygon(lng, lat, simplified) {
+			continue
+		}
+		nearest := math.Inf(1)
+		for j := 0; j+1 < len(ring); j++ {
+			nearest = math.Min(nearest, segmentDistance([]float64{lng, lat}, ring[j], ring[j+1]))
+		}
+		if nearest > tolerance {
+			t.Errorf("(%v, %v) changed containment %v degrees from the original edge, tolerance %v", lng, lat, nearest, tolerance)
+		}
+	}
+}
+
+func BenchmarkContainsDense(b *testing.B) {
+	benchmarkContains(b, 0)
+}
+
+func BenchmarkContainsSimplified(b *testing.B) {
+	benchmarkContains(b, 1e-5)
+}
+
+func benchmarkContains(b *testing.B, tolerance float64) {
+	ring := simplifyRing(denseRing(5000), tolerance)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		isPointInPolygon(0.001, 0.002, ring)
+	}
+}
+
+// fuzzRing decodes data as a ring, four bytes per position: two big-endian
+// int16 values scaled to a 0.01 degree grid, so that fuzzed rings hit shared
+// vertices, collinear runs and horizontal edges often.
+func fuzzRing(data []byte) [][]float64 {
+	ring := make([][]float64, 0, len(data)/4)
+	for i := 0; i+4 <= len(data); i += 4 {
+		lng := float64(int16(binary.BigEndian.Uint16(data[i:]))) / 100
+		lat := float64(int16(binary.BigEndian.Uint16(data[i+2:]))) / 100
+		ring = append(ring, []float64{lng, lat})
+	}
+	return ring
+}
+
+func fuzzRingBytes(ring [][]float64) []byte {
+	data := make([]byte, 0, 4*len(ring))
+	for _, point := range ring {
+		data = binary.BigEndian.AppendUint16(data, uint16(int16(point[0]*100)))
+		data = binary.BigEndian.AppendUint16(data, uint16(int16(point[1]*100)))
+	}
+	return data
+}
+
+func FuzzIsPointInPolygon(f *testing.F) {
+	f.Add(0.5, 0.5, fuzzRingBytes(unitSquare))
+	f.Add(0.75, 0.75, fuzzRingBytes(lShape))
+	f.Add(0.0, 0.0, fuzzRingBytes([][]float64{{0, 0}}))
+	f.Add(0.5, 0.0, fuzzRingBytes([][]float64{{0, 0}, {1, 0}, {2, 0}, {0, 0}}))
+	f.Add(1.0, 1.0, fuzzRingBytes([][]float64{{0, 0}, {1, 1}, {1, 1}, {2, 2}, {0, 2}, {0, 0}, {0, 0}}))
+	f.Add(0.5, 0.5, []byte{})
+	f.Add(math.NaN(), math.Inf(1), fuzzRingBytes(unitSquare))
+
+	f.Fuzz(func(t *testing.T, lng float64, lat float64, data []byte) {
+		ring := fuzzRing(data)
+		got := isPointInPolygon(lng, lat, ring)
+		if len(ring) < 3 && got {
+			t.Fatalf("ring with %d positions contains (%v, %v)", len(ring), lng, lat)
+		}
+		// Edges are evaluated from their lower endpoint, so the winding
+		// order must not change the answer even on the boundary.
+		if reversedGot := isPointInPolygon(lng, lat, reverseRing(ring)); reversedGot != got {
+			t.Fatalf("(%v, %v): ring = %v, reversed ring = %v", lng, lat, got, reversedGot)
+		}
+	})
+}
+
+func TestNormalizeWinding(t *testing.T) {
+	hole := [][]float64{{0.1, 0.1}, {0.1, 0.2}, {0.2, 0.2}, {0.2, 0.1}, {0.1, 0.1}}
+	tests := []struct {
+		name    string
+		polygon Polygon
+		flipped int
+	}{
+		{"already normalized", Polygon{unitSquare, hole}, 0},
+		{"clockwise outer ring", Polygon{reverseRing(lShape)}, 1},
+		{"counter-clockwise hole", Polygon{unitSquare, reverseRing(hole)}, 1},
+		{"both reversed", Polygon{reverseRing(unitSquare), reverseRing(hole)}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalized, flipped := normalizeWinding(tt.polygon)
+			if len(flipped) != tt.flipped {
+				t.Errorf("flipped rings %v, want %d", flipped, tt.flipped)
+			}
+			for i, ring := range normalized {
+				if area := signedRingArea(ring); i == 0 && area <= 0 {
+					t.Errorf("outer ring signed area = %v, want > 0", area)
+				} else if i > 0 && area >= 0 {
+					t.Errorf("hole %d signed area = %v, want < 0", i, area)
+				}
+			}
+		})
+	}
+}
+
+// datasetPolygons returns the polygons of areas.json and random points
+// spread over their combined bounding box.
+func datasetPolygons(tb testing.TB, points int) ([]Polygon, [][2]float64) {
+	featureCollection, err := loadAreas(defaultAreasFile)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var polygons []Polygon
+	for _, feature := range featureCollection.Features {
+		polygons = append(polygons, feature.Geometry.Polygons...)
+	}
+
+	b := bbox{MinLng: math.Inf(1), MinLat: math.Inf(1), MaxLng: math.Inf(-1), MaxLat: math.Inf(-1)}
+	for _, feature := range featureCollection.Features {
+		fb := featureBounds(feature)
+		b.MinLng, b.MinLat = math.Min(b.MinLng, fb.MinLng), math.Min(b.MinLat, fb.MinLat)
+		b.MaxLng, b.MaxLat = math.Max(b.MaxLng, fb.MaxLng), math.Max(b.MaxLat, fb.MaxLat)
+	}
+	rng := rand.New(rand.NewSource(1))
+	queries := make([][2]float64, points)
+	for i := range queries {
+		queries[i] = [2]float64{
+			b.MinLng + rng.Float64()*(b.MaxLng-b.MinLng),
+			b.MinLat + rng.Float64()*(b.MaxLat-b.MinLat),
+		}
+	}
+	return polygons, queries
+}
+
+func TestFlatPolygonMatchesNested(t *testing.T) {
+	polygons, queries := datasetPolygons(t, 2000)
+	for _, polygon := range polygons {
+		flat := newFlatPolygon(polygon)
+		for _, q := range queries {
+			if got, want := flat.contains(q[0], q[1]), polygonContains(q[0], q[1], polygon); got != want {
+				t.Errorf("(%v, %v): flat = %v, nested = %v", q[0], q[1], got, want)
+			}
+		}
+		// Vertices exercise the boundary rule.
+		for _, point := range polygon[0] {
+			if got, want := flat.contains(point[0], point[1]), polygonContains(point[0], point[1], polygon); got != want {
+				t.Errorf("vertex (%v, %v): flat = %v, nested = %v", point[0], point[1], got, want)
+			}
+		}
+	}
+}
+
+func BenchmarkPolygonContainsNested(b *testing.B) {
+	polygons, queries := datasetPolygons(b, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q := queries[i%len(queries)]
+		for _, polygon := range polygons {
+			polygonContains(q[0], q[1], polygon)
+		}
+	}
+}
+
+func BenchmarkPolygonContainsFlat(b *testing.B) {
+	polygons, queries := datasetPolygons(b, 1000)
+	flat := make([]flatPolygon, len(polygons))
+	for i, polygon := range polygons {
+		flat[i] = newFlatPolygon(polygon)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q := queries[i%len(queries)]
+		for _, polygon := range flat {
+			polygon.contains(q[0], q[1])
+		}
+	}
+}
+
+func TestPointFromQuery(t *testing.T) {
+	tests := []struct {
+		lat, lng string
+		wantLat  float64
+		wantLng  float64
+		wantErr  bool
+	}{
+		{"9.59", "41.86", 9.59, 41.86, false},
+		{"9.59 ", "41.86", 9.59, 41.86, false},
+		{" 9.59", "\t41.86\n", 9.59, 41.86, false},
+		{"9.59,", "41.86,", 9.59, 41.86, false},
+		{"9.59 , ", "41.86", 9.59, 41.86, false},
+		{"9.59,,", "41.86", 0, 0, true},
+		{",9.59", "41.86", 0, 0, true},
+		{"9.59", "", 0, 0, true},
+		{" ", "41.86", 0, 0, true},
+		{",", "41.86", 0, 0, true},
+		{"9.5.9", "41.86", 0, 0, true},
+		{"abc", "41.86", 0, 0, true},
+		{"91", "41.86", 0, 0, true},
+		{"90", "180", 90, 180, false},
+		{"-90", "-180", -90, -180, false},
+		{"90.0000001", "41.86", 0, 0, true},
+		{"-90.0000001", "41.86", 0, 0, true},
+		{"9.59", "180.0000001", 0, 0, true},
+		{"9.59", "-180.0000001", 0, 0, true},
+		{"NaN", "41.86", 0, 0, true},
+		{"9.59", "Inf", 0, 0, true},
+		{"9.59", "-Inf", 0, 0, true},
+		{"nan", "41.86", 0, 0, true},
+	}
+	for _, tt := range tests {
+		query := url.Values{"lat": {tt.lat}, "lng": {tt.lng}}
+		lat, lng, err := pointFromQuery(query, "lat", "lng")
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("lat=%q lng=%q: got (%v, %v), want error", tt.lat, tt.lng, lat, lng)
+			}
+			continue
+		}
+		if err != nil || lat != tt.wantLat || lng != tt.wantLng {
+			t.Errorf("lat=%q lng=%q: got (%v, %v, %v), want (%v, %v)", tt.lat, tt.lng, lat, lng, err, tt.wantLat, tt.wantLng)
+		}
+	}
+}
+
+func TestLoadGzippedAreas(t *testing.T) {
+	data, err := os.ReadFile(defaultAreasFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"areas.json.gz", "areas-no-extension"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, compressed.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		want, err := loadAreas(defaultAreasFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := loadAreas(path)
+		if err != nil {
+			t.Fatalf("loadAreas(%s): %v", name, err)
+		}
+		if len(got.Features) != len(want.Features) {
+			t.Errorf("loadAreas(%s) loaded %d features, want %d", name, len(got.Features), len(want.Features))
+		}
+	}
+}
+
+func BenchmarkLoadAreas(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := loadAreas(defaultAreasFile); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// writeSyntheticAreas writes a rows×cols grid of square areas of side cell
+// degrees, each with vertices points per edge, to an areas.json in a temp
+// directory and returns its path. Feature i covers row i/cols, column i%cols
+// counting from (lng, lat) = (0, 0).
+func writeSyntheticAreas(tb testing.TB, rows int, cols int, cell float64, vertices int) string {
+	featureCollection := FeatureCollection{Type: "FeatureCollection"}
+	for i := 0; i < rows*cols; i++ {
+		minLng, minLat := float64(i%cols)*cell, float64(i/cols)*cell
+		corners := [][2]float64{{minLng, minLat}, {minLng + cell, minLat}, {minLng + cell, minLat + cell}, {minLng, minLat + cell}}
+		var ring [][]float64
+		for c, from := range corners {
+			to := corners[(c+1)%len(corners)]
+			for v := 0; v < vertices; v++ {
+				t := float64(v) / float64(vertices)
+				ring = append(ring, []float64{from[0] + t*(to[0]-from[0]), from[1] + t*(to[1]-from[1])})
+			}
+		}
+		ring = append(ring, ring[0])
+		featureCollection.Features = append(featureCollection.Features, Feature{
+			Type:       "Feature",
+			Properties: Properties{Name: fmt.Sprintf("Area %d", i), Id: fmt.Sprintf("area-%d", i)},
+			Geometry:   Geometry{Type: "Polygon", Polygons: []Polygon{{ring}}},
+		})
+	}
+
+	data, err := json.Marshal(featureCollection)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	path := filepath.Join(tb.TempDir(), "areas.json")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+func BenchmarkFindArea(b *testing.B) {
+	const rows, cols, cell = 10, 10, 0.01
+	g, err := NewGeocoderFromFile(writeSyntheticAreas(b, rows, cols, cell, 64))
+	if err != nil {
+		b.Fatal(err)
+	}
+	// Measure the lookups themselves rather than cache hits.
+	g.SetCacheSize(0)
+
+	mid := rows * cols / 2
+	insideLng, insideLat := (float64(mid%cols)+0.5)*cell, (float64(mid/cols)+0.5)*cell
+	if name, _, ok, _ := g.Lookup(insideLng, insideLat); !ok || name != fmt.Sprintf("Area %d", mid) {
+		b.Fatalf("Lookup(%v, %v) = %q, %v; want Area %d", insideLng, insideLat, name, ok, mid)
+	}
+
+	benchmarks := []struct {
+		name     string
+		lng, lat float64
+	}{
+		{"Inside", insideLng, insideLat},
+		{"Fallback", -cell, -cell},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				g.findFeature(bm.lng, bm.lat)
+			}
+		})
+	}
+}
+
+func TestEmptyAreasReportDegraded(t *testing.T) {
+	for name, data := range map[string]string{
+		"empty": `{"type":"FeatureCollection","features":[]}`,
+		"null":  `{"type":"FeatureCollection","features":null}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "areas.json")
+			if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			g, err := NewGeocoderFromFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			g.healthzHandler(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if recorder.Code != http.StatusServiceUnavailable {
+				t.Errorf("status = %d, want %d", recorder.Code, http.StatusServiceUnavailable)
+			}
+			if body := recorder.Body.String(); !strings.Contains(body, `"degraded"`) {
+				t.Errorf("body = %s, want status degraded", body)
+			}
+		})
+	}
+}
+
+func TestParseWKTPoint(t *testing.T) {
+	tests := []struct {
+		text     string
+		lng, lat float64
+		ok       bool
+	}{
+		{"POINT(41.86 9.59)", 41.86, 9.59, true},
+		{"  point ( 41.86\t9.59 )\n", 41.86, 9.59, true},
+		{"POINT(-0.5 51.5)", -0.5, 51.5, true},
+		{"POINT(41.86)", 0, 0, false},
+		{"POINT(41.86 9.59 100)", 0, 0, false},
+		{"POINT Z (41.86 9.59 100)", 0, 0, false},
+		{"POINT EMPTY", 0, 0, false},
+		{"POINT(a b)", 0, 0, false},
+		{"LINESTRING(0 0, 1 1)", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		lng, lat, err := parseWKTPoint(tt.text)
+		if ok := err == nil; ok != tt.ok || lng != tt.lng || lat != tt.lat {
+			t.Errorf("parseWKTPoint(%q) = %v, %v, %v; want %v, %v, ok %v", tt.text, lng, lat, err, tt.lng, tt.lat, tt.ok)
+		}
+	}
+}
+
+func TestPolygonWKTRoundTrip(t *testing.T) {
+	withHole := Polygon{
+		{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}},
+		{{2, 2}, {2, 4}, {4, 4}, {4, 2}, {2, 2}},
+	}
+	for _, text := range []string{
+		"POLYGON((41.5 9.25,41.75 9.25,41.75 9.5,41.5 9.25))",
+		polygonToWKT(withHole),
+		"POLYGON((-0.125 51.5,0.25 51.5,0.25 51.75,-0.125 51.5),(0 51.55,0.1 51.55,0.1 51.6,0 51.55))",
+	} {
+		polygon, err := parseWKTPolygon(text)
+		if err != nil {
+			t.Fatalf("parseWKTPolygon(%q): %v", text, err)
+		}
+		if got := polygonToWKT(polygon); got != text {
+			t.Errorf("round trip of %q = %q", text, got)
+		}
+	}
+
+	polygon, err := parseWKTPolygon(polygonToWKT(withHole))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(polygon) != 2 || polygonContains(3, 3, polygon) || !polygonContains(5, 5, polygon) {
+		t.Errorf("parsed polygon %v lost its hole", polygon)
+	}
+
+	for _, text := range []string{"POLYGON()", "POLYGON((0 0,1 1)", "POLYGON((0 0,1 1),)", "POINT(0 0)"} {
+		if _, err := parseWKTPolygon(text); err == nil {
+			t.Errorf("parseWKTPolygon(%q) succeeded, want error", text)
+		}
+	}
+}
+
+func TestPointInPolygonAlgorithmsAgree(t *testing.T) {
+	ray, winding := rayCasting{}, windingNumber{}
+
+	// Away from edges the two algorithms must agree exactly.
+	polygons, queries := datasetPolygons(t, 2000)
+	for _, polygon := range polygons {
+		flat := newFlatPolygon(polygon)
+		for _, q := range queries {
+			if nearEdge(q[0], q[1], polygon, 1e-9) {
+				continue
+			}
+			if got, want := winding.Contains(q[0], q[1], flat), ray.Contains(q[0], q[1], flat); got != want {
+				t.Errorf("(%v, %v): winding = %v, ray = %v", q[0], q[1], got, want)
+			}
+		}
+	}
+
+	// On exact boundaries both follow the closed south-west, open
+	// north-east rule.
+	withHole := newFlatPolygon(Polygon{
+		{{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 0}},
+		{{1, 1}, {1, 3}, {3, 3}, {3, 1}, {1, 1}},
+	})
+	tests := []struct {
+		lng, lat float64
+		want     bool
+	}{
+		{0, 2, true},   // west edge
+		{4, 2, false},  // east edge
+		{2, 0, true},   // south edge
+		{2, 4, false},  // north edge
+		{0, 0, true},   // south-west corner
+		{4, 4, false},  // north-east corner
+		{2, 2, false},  // inside the hole
+		{0.5, 2, true}, // between outer ring and hole
+		{3, 2, true},   // east edge of the hole
+		{1, 2, false},  // west edge of the hole
+		{2, 3, true},   // north edge of the hole
+		{2, 1, false},  // south edge of the hole
+		{5, 2, false},  // outside
+	}
+	for _, tt := range tests {
+		for name, algorithm := range pointInPolygonAlgorithms {
+			if got := algorithm.Contains(tt.lng, tt.lat, withHole); got != tt.want {
+				t.Errorf("%s: Contains(%v, %v) = %v, want %v", name, tt.lng, tt.lat, got, tt.want)
+			}
+		}
+	}
+}
+
+// nearEdge reports whether the point is within tolerance degrees of any edge
+// of polygon.
+func nearEdge(lng float64, lat float64, polygon Polygon, tolerance float64) bool {
+	p := []float64{lng, lat}
+	for _, ring := range polygon {
+		for i := range ring {
+			if segmentDistance(p, ring[i], ring[(i+1)%len(ring)]) <= tolerance {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestWatchReloadsChangedFile(t *testing.T) {
+	square := func(name string) string {
+		return `{"type":"FeatureCollection","features":[{"type":"Feature",
+			"properties":{"name":"` + name + `","id":"square"},
+			"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}}]}`
+	}
+	path := filepath.Join(t.TempDir(), "areas.json")
+	if err := os.WriteFile(path, []byte(square("Before")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewGeocoderFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Watch(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte(square("After")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		name, _, _, _ := g.Lookup(0.5, 0.5)
+		if name == "After" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Lookup = %q after rewriting the file, want After", name)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// A broken rewrite keeps the areas loaded before it.
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * watchDebounce)
+	if name, _, _, _ := g.Lookup(0.5, 0.5); name != "After" {
+		t.Errorf("Lookup = %q after a broken rewrite, want After", name)
+	}
+}
+
+func TestJSONPCallback(t *testing.T) {
+	handler := jsonp(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "OK"})
+	}))
+	tests := []struct {
+		callback    string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"", http.StatusOK, jsonContentType, `{"status":"OK"}`},
+		{"handle", http.StatusOK, "application/javascript", `/**/handle({"status":"OK"});`},
+		{"app.on_geocode$1", http.StatusOK, "application/javascript", `/**/app.on_geocode$1({"status":"OK"});`},
+		{"alert(1)//", http.StatusBadRequest, jsonContentType, ""},
+		{"a.b.", http.StatusBadRequest, jsonContentType, ""},
+		{"1abc", http.StatusBadRequest, jsonContentType, ""},
+		{"<script>", http.StatusBadRequest, jsonContentType, ""},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/?callback="+url.QueryEscape(tt.callback), nil))
+		if recorder.Code != tt.status || recorder.Header().Get("Content-Type") != tt.contentType {
+			t.Errorf("callback %q: status %d, Content-Type %q; want %d, %q",
+				tt.callback, recorder.Code, recorder.Header().Get("Content-Type"), tt.status, tt.contentType)
+		}
+		if tt.body != "" && recorder.Body.String() != tt.body {
+			t.Errorf("callback %q: body %s, want %s", tt.callback, recorder.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestDisabledFeatureNeverMatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "areas.json")
+	data := `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"name":"Inner","id":"inner","enabled":false},
+			"geometry":{"type":"Polygon","coordinates":[[[0.25,0.25],[0.75,0.25],[0.75,0.75],[0.25,0.75],[0.25,0.25]]]}},
+		{"type":"Feature","properties":{"name":"Outer","id":"outer","enabled":true},
+			"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}},
+		{"type":"Feature","properties":{"name":"Off","id":"off","enabled":false},
+			"geometry":{"type":"Polygon","coordinates":[[[2,2],[3,2],[3,3],[2,3],[2,2]]]}}]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewGeocoderFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The smaller Inner area would win if it were enabled.
+	if name, _, ok, _ := g.Lookup(0.5, 0.5); !ok || name != "Outer" {
+		t.Errorf("Lookup(0.5, 0.5) = %q, %v; want Outer", name, ok)
+	}
+	if name, _, ok, _ := g.Lookup(2.5, 2.5); ok {
+		t.Errorf("Lookup(2.5, 2.5) = %q inside a disabled area, want no match", name)
+	}
+	for _, feature := range g.findAllFeatures(0.5, 0.5) {
+		if feature.Properties.Id != "outer" {
+			t.Errorf("findAllFeatures returned disabled %q", feature.Properties.Id)
+		}
+	}
+	if feature, _ := g.findNearestFeature(2.5, 2.5); feature == nil || feature.Properties.Id != "outer" {
+		t.Errorf("findNearestFeature(2.5, 2.5) = %v, want outer", feature)
+	}
+}
+
+func TestDecodePolyline(t *testing.T) {
+	tests := []struct {
+		encoded string
+		want    []Point
+	}{
+		// The example from Google's polyline algorithm documentation.
+		{"_p~iF~ps|U_ulLnnqC_mqNvxq`@", []Point{{Lng: -120.2, Lat: 38.5}, {Lng: -120.95, Lat: 40.7}, {Lng: -126.453, Lat: 43.252}}},
+		// Its worked example of encoding -179.9832104.
+		{"`~oia@`~oia@", []Point{{Lng: -179.98321, Lat: -179.98321}}},
+		{"??", []Point{{Lng: 0, Lat: 0}}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := decodePolyline(tt.encoded)
+		if err != nil {
+			t.Errorf("decodePolyline(%q): %v", tt.encoded, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("decodePolyline(%q) = %v, want %v", tt.encoded, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if math.Abs(got[i].Lng-tt.want[i].Lng) > 1e-9 || math.Abs(got[i].Lat-tt.want[i].Lat) > 1e-9 {
+				t.Errorf("decodePolyline(%q)[%d] = %v, want %v", tt.encoded, i, got[i], tt.want[i])
+			}
+		}
+	}
+
+	for _, encoded := range []string{"_p~iF", "_p~iF~ps|U_", " ?"} {
+		if points, err := decodePolyline(encoded); err == nil {
+			t.Errorf("decodePolyline(%q) = %v, want error", encoded, points)
+		}
+	}
+}
+
+func TestFindFeaturesKeepsOrder(t *testing.T) {
+	g, err := NewGeocoderFromFile(defaultAreasFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, queries := datasetPolygons(t, 5000)
+	points := make([]Point, len(queries))
+	for i, q := range queries {
+		points[i] = Point{Lng: q[0], Lat: q[1]}
+	}
+
+	defer func(workers int) { batchWorkers = workers }(batchWorkers)
+	batchWorkers = 8
+	features, err := g.findFeatures(context.Background(), points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, feature := range features {
+		if want := g.findFeature(points[i].Lng, points[i].Lat); feature != want {
+			t.Fatalf("point %d: parallel lookup = %v, sequential = %v", i, feature, want)
+		}
+	}
+}
+
+func BenchmarkBatch(b *testing.B) {
+	g, err := NewGeocoderFromFile(defaultAreasFile)
+	if err != nil {
+		b.Fatal(err)
+	}
+	_, queries := datasetPolygons(b, 10000)
+	points := make([]Point, len(queries))
+	for i, q := range queries {
+		points[i] = Point{Lng: q[0], Lat: q[1]}
+	}
+
+	defer func(workers int) { batchWorkers = workers }(batchWorkers)
+	for _, workers := range []int{1, max(runtime.GOMAXPROCS(0), 4)} {
+		name := "Sequential"
+		if workers > 1 {
+			name = fmt.Sprintf("Parallel%d", workers)
+		}
+		b.Run(name, func(b *testing.B) {
+			batchWorkers = workers
+			for i := 0; i < b.N; i++ {
+				g.findFeatures(context.Background(), points)
+			}
+		})
+	}
+}
+
+func TestFillRulesOnSelfIntersectingRings(t *testing.T) {
+	// A pentagram drawn as one ring: the central pentagon is enclosed twice.
+	var star [][]float64
+	for k := 0; k <= 5; k++ {
+		angle := (90 + 144*float64(k%5)) * math.Pi / 180
+		star = append(star, []float64{math.Cos(angle), math.Sin(angle)})
+	}
+	// A figure-eight bowtie: each lobe is enclosed once, in opposite
+	// directions.
+	bowtie := [][]float64{{0, 0}, {2, 2}, {2, 0}, {0, 2}, {0, 0}}
+
+	tests := []struct {
+		name             string
+		ring             [][]float64
+		lng, lat         float64
+		evenOdd, nonzero bool
+	}{
+		{"star center", star, 0, 0, false, true},
+		{"star tip", star, 0, 0.8, true, true},
+		{"outside star", star, 0.9, 0.9, false, false},
+		{"bowtie left lobe", bowtie, 0.25, 1, true, true},
+		{"bowtie right lobe", bowtie, 1.75, 1, true, true},
+		{"between bowtie lobes", bowtie, 1, 1.75, false, false},
+	}
+	for _, tt := range tests {
+		flat := newFlatPolygon(Polygon{tt.ring})
+		if got := fillRules["evenodd"].Contains(tt.lng, tt.lat, flat); got != tt.evenOdd {
+			t.Errorf("%s: evenodd = %v, want %v", tt.name, got, tt.evenOdd)
+		}
+		if got := fillRules["nonzero"].Contains(tt.lng, tt.lat, flat); got != tt.nonzero {
+			t.Errorf("%s: nonzero = %v, want %v", tt.name, got, tt.nonzero)
+		}
+	}
+
+	g := NewGeocoder(FeatureCollection{Features: []Feature{{
+		Properties: Properties{Name: "Star", Id: "star"},
+		Geometry:   Geometry{Type: "Polygon", Polygons: []Polygon{{star}}},
+	}}})
+	if feature, _ := g.findFeatureContext(context.Background(), fillRules["evenodd"], 0, 0); feature != nil {
+		t.Errorf("evenodd lookup of the star center = %q, want no match", feature.Properties.Id)
+	}
+	if feature, _ := g.findFeatureContext(context.Background(), fillRules["nonzero"], 0, 0); feature == nil {
+		t.Errorf("nonzero lookup of the star center matched nothing, want star")
+	}
+}
+
+func TestLoadWebMercatorAreas(t *testing.T) {
+	// The square from 41.8,9.5 to 41.9,9.7, projected to EPSG:3857.
+	project := func(lng, lat float64) []float64 {
+		return []float64{
+			lng * math.Pi / 180 * webMercatorRadius,
+			math.Log(math.Tan(math.Pi/4+lat*math.Pi/360)) * webMercatorRadius,
+		}
+	}
+	ring := [][]float64{project(41.8, 9.5), project(41.9, 9.5), project(41.9, 9.7), project(41.8, 9.7), project(41.8, 9.5)}
+	coordinates, err := json.Marshal([][][]float64{ring})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(crs string) string {
+		data := `{"type":"FeatureCollection","features":[{"type":"Feature",
+			"properties":{"name":"Mercator","id":"mercator"},
+			"geometry":{"type":"Polygon","coordinates":` + string(coordinates) + `}}],
+			"crs":{"type":"name","properties":{"name":"` + crs + `"}}}`
+		path := filepath.Join(t.TempDir(), "areas.json")
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	g, err := NewGeocoderFromFile(write("urn:ogc:def:crs:EPSG::3857"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _, ok, _ := g.Lookup(41.85, 9.6); !ok || name != "Mercator" {
+		t.Errorf("Lookup(41.85, 9.6) = %q, %v; want Mercator", name, ok)
+	}
+	feature := g.currentAreas().areas[0]
+	if corner := feature.Geometry.Polygons[0][0][2]; math.Abs(corner[0]-41.9) > 1e-9 || math.Abs(corner[1]-9.7) > 1e-9 {
+		t.Errorf("reprojected corner = %v, want [41.9 9.7]", corner)
+	}
+	data, err := json.Marshal(feature.Geometry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encoded struct {
+		Type        string        `json:"type"`
+		Coordinates [][][]float64 `json:"coordinates"`
+	}
+	if err := json.Unmarshal(data, &encoded); err != nil || encoded.Type != "Polygon" || math.Abs(encoded.Coordinates[0][0][0]-41.8) > 1e-9 {
+		t.Errorf("encoded geometry %s is not the reprojected Polygon", data)
+	}
+
+	if _, err := NewGeocoderFromFile(write("EPSG:32637")); err == nil || !strings.Contains(err.Error(), "unsupported crs") {
+		t.Errorf("loading EPSG:32637 = %v, want an unsupported crs error", err)
+	}
+}
+
+// slowPointInPolygon is ray casting that takes at least delay per test.
+type slowPointInPolygon struct{ delay time.Duration }
+
+func (s slowPointInPolygon) Contains(lng float64, lat float64, polygon flatPolygon) bool {
+	time.Sleep(s.delay)
+	return rayCasting{}.Contains(lng, lat, polygon)
+}
+
+func TestSlowLookupTimesOut(t *testing.T) {
+	// Ten nested squares, each smaller than the last so that every one has
+	// to be tested, and each taking 20ms to test.
+	featureCollection := FeatureCollection{Type: "FeatureCollection"}
+	for i := 0; i < 10; i++ {
+		side := 1 - 0.05*float64(i)
+		square := [][]float64{{0, 0}, {side, 0}, {side, side}, {0, side}, {0, 0}}
+		featureCollection.Features = append(featureCollection.Features, Feature{
+			Properties: Properties{Name: fmt.Sprintf("Area %d", i), Id: fmt.Sprintf("area-%d", i)},
+			Geometry:   Geometry{Type: "Polygon", Polygons: []Polygon{{square}}},
+		})
+	}
+	g := NewGeocoder(featureCollection)
+	slow := slowPointInPolygon{delay: 20 * time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := g.findFeatureContext(ctx, slow, 0.25, 0.25); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("findFeatureContext = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("timed out lookup took %v, want it abandoned soon after the deadline", elapsed)
+	}
+
+	defer func(algorithm PointInPolygon, timeout time.Duration) {
+		pointInPolygon, lookupTimeout = algorithm, timeout
+	}(pointInPolygon, lookupTimeout)
+	pointInPolygon, lookupTimeout = slow, 50*time.Millisecond
+
+	recorder := httptest.NewRecorder()
+	g.geocodeHandler(recorder, httptest.NewRequest(http.MethodGet, "/geocode?lat=0.25&lng=0.25", nil))
+	if recorder.Code != http.StatusGatewayTimeout {
+		t.Errorf("geocode status = %d, want %d", recorder.Code, http.StatusGatewayTimeout)
+	}
+	recorder = httptest.NewRecorder()
+	g.batchGeocodeHandler(recorder, httptest.NewRequest(http.MethodPost, "/geocode/batch", strings.NewReader(`[{"lat":0.25,"lng":0.25}]`)))
+	if recorder.Code != http.StatusGatewayTimeout {
+		t.Errorf("batch status = %d, want %d", recorder.Code, http.StatusGatewayTimeout)
+	}
+}
+
+func TestGeocodeETagChangesOnReload(t *testing.T) {
+	square := func(id string) FeatureCollection {
+		return FeatureCollection{Type: "FeatureCollection", Features: []Feature{{
+			Properties: Properties{Name: "Square", Id: id},
+			Geometry:   Geometry{Type: "Polygon", Polygons: []Polygon{{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}}}},
+		}}}
+	}
+	g := NewGeocoder(square("square"))
+	geocode := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/geocode?lat=0.5&lng=0.5", nil)
+		if ifNoneMatch != "" {
+			request.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		recorder := httptest.NewRecorder()
+		g.geocodeHandler(recorder, request)
+		return recorder
+	}
+
+	etag := geocode("").Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("geocode response has no ETag")
+	}
+	if recorder := geocode(etag); recorder.Code != http.StatusNotModified {
+		t.Errorf("revalidated status = %d, want %d", recorder.Code, http.StatusNotModified)
+	}
+
+	g.setAreas(square("renamed"))
+	recorder := geocode(etag)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("status after reload = %d, want %d", recorder.Code, http.StatusOK)
+	}
+	if recorder.Header().Get("ETag") == etag {
+		t.Error("ETag unchanged after reloading different areas")
+	}
+}
+
+func TestConfigFlagsOverrideFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"http-addr":["0.0.0.0:80","[::]:80"],"fallback-name":"From File","lookup-timeout":"2s","allowed-origins":["https://a.example","https://b.example"]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	httpAddr := flags.String("http-addr", "127.0.0.1:8080", "")
+	fallbackName := flags.String("fallback-name", "Dire Dawa", "")
+	timeout := flags.Duration("lookup-timeout", 5*time.Second, "")
+	origins := flags.String("allowed-origins", "", "")
+	if err := flags.Parse([]string{"-fallback-name", "From Flag"}); err != nil {
+		t.Fatal(err)
+	}
+	explicit := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	if err := config.apply(flags, explicit); err != nil {
+		t.Fatal(err)
+	}
+
+	if *httpAddr != "0.0.0.0:80,[::]:80" || *timeout != 2*time.Second || *origins != "https://a.example,https://b.example" {
+		t.Errorf("file settings = %q, %v, %q; want them applied", *httpAddr, *timeout, *origins)
+	}
+	if *fallbackName != "From Flag" {
+		t.Errorf("fallback-name = %q, want the flag to override the file", *fallbackName)
+	}
+
+	// Config files written when http-addr took a single address still load.
+	if err := os.WriteFile(path, []byte(`{"http-addr":"0.0.0.0:80"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if config, err := loadConfig(path); err != nil || !reflect.DeepEqual(config.HTTPAddr, StringList{"0.0.0.0:80"}) {
+		t.Errorf("loadConfig(string http-addr) = %q, %v; want [0.0.0.0:80]", config.HTTPAddr, err)
+	}
+
+	for _, bad := range []string{`{"rate-burst":0}`, `{"lookup-timeout":5}`, `{"http_addr":"x"}`, `{"http-addr":80}`} {
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadConfig(path); err == nil {
+			t.Errorf("loadConfig(%s) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestFindNearestFeaturesMatchesFullSort(t *testing.T) {
+	g, err := NewGeocoderFromFile(writeSyntheticAreas(t, 8, 8, 0.01, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	areas := g.currentAreas().areas
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		lng, lat := rng.Float64()*0.1-0.01, rng.Float64()*0.1-0.01
+		distances := make([]float64, len(areas))
+		for j := range areas {
+			distances[j] = featureDistance(&areas[j], lng, lat)
+		}
+		sort.Float64s(distances)
+
+		for _, k := range []int{1, 5, len(areas) + 3} {
+			nearest := g.findNearestFeatures(lng, lat, k)
+			if want := min(k, len(areas)); len(nearest) != want {
+				t.Fatalf("findNearestFeatures(k=%d) returned %d features, want %d", k, len(nearest), want)
+			}
+			for j, n := range nearest {
+				if n.distance != distances[j] {
+					t.Errorf("(%v, %v) k=%d: distance %d = %v, want %v", lng, lat, k, j, n.distance, distances[j])
+				}
+			}
+		}
+	}
+}
+
+func TestFeaturePerimeterKm(t *testing.T) {
+	// One degree along the equator or a meridian is 111.195 km.
+	const degreeKm = earthRadiusMeters / 1000 * math.Pi / 180
+	outer := [][]float64{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}
+	if got, want := polygonPerimeterKm(outer), (3+math.Cos(math.Pi/180))*degreeKm; math.Abs(got-want) > 0.01 {
+		t.Errorf("polygonPerimeterKm(square) = %v, want %v", got, want)
+	}
+	if got, closed := polygonPerimeterKm(outer[:4]), polygonPerimeterKm(outer); got != closed {
+		t.Errorf("unclosed ring perimeter = %v, want the closed ring's %v", got, closed)
+	}
+
+	hole := [][]float64{{0.25, 0.25}, {0.25, 0.75}, {0.75, 0.75}, {0.75, 0.25}, {0.25, 0.25}}
+	feature := Feature{Geometry: Geometry{Type: "Polygon", Polygons: []Polygon{{outer, hole}}}}
+	if got, want := featurePerimeterKm(feature), polygonPerimeterKm(outer)+polygonPerimeterKm(hole); got != want {
+		t.Errorf("featurePerimeterKm = %v, want outer plus hole %v", got, want)
+	}
+}
+
+func TestGeocodeCityFilter(t *testing.T) {
+	// Two tenants' zones cover the same square; the smaller one wins without
+	// a filter.
+	zone := func(id string, city string, side float64) Feature {
+		return Feature{
+			Properties: Properties{Name: id, Id: id, Extra: map[string]any{"city": city}},
+			Geometry:   Geometry{Type: "Polygon", Polygons: []Polygon{{{{0, 0}, {side, 0}, {side, side}, {0, side}, {0, 0}}}}},
+		}
+	}
+	g := NewGeocoder(FeatureCollection{Type: "FeatureCollection", Features: []Feature{
+		zone("dire-dawa-zone", "Dire Dawa", 1), zone("harar-zone", "Harar", 0.8),
+	}})
+	geocode := func(query string) (int, GeocodeResponse) {
+		recorder := httptest.NewRecorder()
+		g.geocodeHandler(recorder, httptest.NewRequest(http.MethodGet, "/geocode?lat=0.5&lng=0.5&strict=true&"+query, nil))
+		var response GeocodeResponse
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		return recorder.Code, response
+	}
+
+	tests := []struct {
+		query  string
+		status int
+		want   string
+	}{
+		{"", http.StatusOK, "harar-zone"},
+		{"city=Dire+Dawa", http.StatusOK, "dire-dawa-zone"},
+		{"filterKey=city&filterValue=Harar", http.StatusOK, "harar-zone"},
+		{"city=Addis+Ababa", http.StatusOK, ""},
+		{"filterKey=tenant&filterValue=a", http.StatusBadRequest, ""},
+		{"filterKey=city", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		status, response := geocode(tt.query)
+		if status != tt.status {
+			t.Errorf("%q: status = %d, want %d", tt.query, status, tt.status)
+			continue
+		}
+		var got string
+		if len(response.Results) > 0 {
+			got = response.Results[0].PlaceId
+		}
+		if got != tt.want {
+			t.Errorf("%q: place_id = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestOpenAPISchemasMatchResponseTypes(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	openapiHandler(recorder, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	var spec struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+
+	types := map[string]any{
+		"GeocodeResponse":  GeocodeResponse{},
+		"Result":           Result{},
+		"AddressComponent": AddressComponent{},
+		"ResultGeometry":   ResultGeometry{},
+		"Viewport":         Viewport{},
+		"Location":         Location{},
+		"ResultDebug":      ResultDebug{},
+		"AreaSummary":      areaSummary{},
+		"Health":           healthResponse{},
+		"ErrorResponse":    ErrorResponse{},
+	}
+	for name, v := range types {
+		schema, ok := spec.Components.Schemas[name]
+		if !ok {
+			t.Errorf("openapi.json has no %s schema", name)
+			continue
+		}
+		var fields []string
+		typ := reflect.TypeOf(v)
+		for i := 0; i < typ.NumField(); i++ {
+			if field, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ","); field != "" && field != "-" {
+				fields = append(fields, field)
+			}
+		}
+		var properties []string
+		for property := range schema.Properties {
+			properties = append(properties, property)
+		}
+		sort.Strings(fields)
+		sort.Strings(properties)
+		if !reflect.DeepEqual(fields, properties) {
+			t.Errorf("%s schema properties = %v, want the fields of %T: %v", name, properties, v, fields)
+		}
+	}
+}
+
+func TestPointFeaturesArePOIs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "areas.json")
+	data := `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"name":"Square","id":"square"},
+		 "geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}},
+		{"type":"Feature","properties":{"name":"Station","id":"station","kind":"rail"},
+		 "geometry":{"type":"Point","coordinates":[0.5,0.5]}},
+		{"type":"Feature","properties":{"name":"Market","id":"market"},
+		 "geometry":{"type":"Point","coordinates":[0.51,0.5]}},
+		{"type":"Feature","properties":{"name":"Kiosk","id":"kiosk"},
+		 "geometry":{"type":"Point","coordinates":[0.502]}}]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewGeocoderFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(g.currentAreas().areas); got != 1 {
+		t.Errorf("loaded %d areas, want only the polygon", got)
+	}
+	// The kiosk has a single coordinate and is skipped, not fatal.
+	if got := len(g.currentAreas().pois); got != 2 {
+		t.Errorf("loaded %d POIs, want the station and the market", got)
+	}
+	if name, _, ok, _ := g.Lookup(0.5, 0.5); !ok || name != "Square" {
+		t.Errorf("Lookup = %q, %v; want Square", name, ok)
+	}
+
+	poi, distance, ok := g.nearestPOI(0.502, 0.5, 1000)
+	if !ok || poi.Properties.Id != "station" || poi.Properties.Extra["kind"] != "rail" {
+		t.Errorf("nearestPOI = %+v, %v; want station with its properties", poi.Properties, ok)
+	}
+	if want := haversineMeters(0.502, 0.5, 0.5, 0.5); math.Abs(distance-want) > 1e-9 {
+		t.Errorf("nearestPOI distance = %v, want %v", distance, want)
+	}
+	if _, _, ok := g.nearestPOI(0.502, 0.5, 100); ok {
+		t.Error("nearestPOI found a POI farther than the radius")
+	}
+}
+
+func TestJSONResponsesDeclareUTF8(t *testing.T) {
+	g := NewGeocoder(FeatureCollection{Type: "FeatureCollection", Features: []Feature{{
+		Properties: Properties{Name: "Square", Id: "square"},
+		Geometry:   Geometry{Type: "Polygon", Polygons: []Polygon{{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}}}},
+	}}})
+	defer func(name string) { fallbackLocality = name }(fallbackLocality)
+	tests := []struct {
+		handler      http.HandlerFunc
+		target       string
+		fallbackName string
+		status       int
+		contentType  string
+	}{
+		{g.geocodeHandler, "/geocode?lat=0.5&lng=0.5", "Dire Dawa", http.StatusOK, jsonContentType},
+		{g.geocodeHandler, "/geocode?lat=100&lng=0.5", "Dire Dawa", http.StatusBadRequest, jsonContentType},
+		{g.geocodeHandler, "/geocode?lat=0.5&lng=0.5&format=geojson", "Dire Dawa", http.StatusOK, geoJSONContentType},
+		{g.geocodeHandler, "/geocode?lat=5&lng=5&format=geojson", "Dire Dawa", http.StatusOK, geoJSONContentType},
+		{g.geocodeHandler, "/geocode?lat=5&lng=5&strict=true&format=geojson", "Dire Dawa", http.StatusNotFound, jsonContentType},
+		{g.geocodeHandler, "/geocode?lat=5&lng=5&format=geojson", "", http.StatusNotFound, jsonContentType},
+		{g.areasHandler, "/areas", "Dire Dawa", http.StatusOK, jsonContentType},
+		{g.areasGeoJSONHandler, "/areas.geojson", "Dire Dawa", http.StatusOK, geoJSONContentType},
+		{g.healthzHandler, "/healthz", "Dire Dawa", http.StatusOK, jsonContentType},
+		{openapiHandler, "/openapi.json", "Dire Dawa", http.StatusOK, jsonContentType},
+	}
+	for _, tt := range tests {
+		fallbackLocality = tt.fallbackName
+		recorder := httptest.NewRecorder()
+		tt.handler(recorder, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if recorder.Code != tt.status {
+			t.Errorf("%s with fallback %q: status = %d, want %d", tt.target, tt.fallbackName, recorder.Code, tt.status)
+		}
+		if got := recorder.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s with fallback %q: Content-Type = %q, want %q", tt.target, tt.fallbackName, got, tt.contentType)
+		}
+	}
+}
+
+func TestNonFiniteCoordinatesAreRejected(t *testing.T) {
+	g := NewGeocoder(FeatureCollection{Type: "FeatureCollection"})
+	for _, query := range []string{"lat=NaN&lng=41.86", "lat=9.59&lng=Inf", "lat=9.59&lng=-Inf", "latlng=NaN,41.86"} {
+		recorder := httptest.NewRecorder()
+		g.geocodeHandler(recorder, httptest.NewRequest(http.MethodGet, "/geocode?"+query, nil))
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", query, recorder.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestRegionsServeSeparateDatasets(t *testing.T) {
+	regions, err := parseRegions("ethiopia=et.json, kenya = ke.json")
+	if err != nil || !reflect.DeepEqual(regions, map[string]string{"ethiopia": "et.json", "kenya": "ke.json"}) {
+		t.Fatalf("parseRegions = %v, %v", regions, err)
+	}
+	for _, bad := range []string{"kenya", "kenya=", "ke/nya=ke.json", "kenya=a.json,kenya=b.json"} {
+		if _, err := parseRegions(bad); err == nil {
+			t.Errorf("parseRegions(%q) succeeded, want an error", bad)
+		}
+	}
+
+	square := func(id string, lng float64, lat float64) *Geocoder {
+		return NewGeocoder(FeatureCollection{Type: "FeatureCollection", Features: []Feature{{
+			Properties: Properties{Name: id, Id: id},
+			Geometry: Geometry{Type: "Polygon", Polygons: []Polygon{{{
+				{lng, lat}, {lng + 1, lat}, {lng + 1, lat + 1}, {lng, lat + 1}, {lng, lat},
+			}}}},
+		}}})
+	}
+	mux := http.NewServeMux()
+	registerDataset(mux, regionPrefix+"ethiopia", square("dire-dawa", 41, 9), false)
+	registerDataset(mux, regionPrefix+"kenya", square("nairobi", 36, -2), true)
+
+	tests := []struct {
+		method, target string
+		status         int
+		placeID        string
+	}{
+		{http.MethodGet, "/region/ethiopia/geocode?lat=9.5&lng=41.5", http.StatusOK, "dire-dawa"},
+		{http.MethodGet, "/region/kenya/geocode?lat=-1.5&lng=36.5", http.StatusOK, "nairobi"},
+		{http.MethodGet, "/region/kenya/geocode?lat=9.5&lng=41.5", http.StatusOK, "fallback"},
+		{http.MethodGet, "/region/kenya/healthz", http.StatusOK, ""},
+		{http.MethodPost, "/region/kenya/reload", http.StatusForbidden, ""},
+		{http.MethodGet, "/region/mars/geocode?lat=9.5&lng=41.5", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.target, nil))
+		if recorder.Code != tt.status {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.target, recorder.Code, tt.status)
+			continue
+		}
+		if tt.placeID == "" {
+			continue
+		}
+		var response GeocodeResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || len(response.Results) != 1 {
+			t.Fatalf("%s: results = %s", tt.target, recorder.Body.String())
+		}
+		if got := response.Results[0].PlaceId; !strings.HasPrefix(got, tt.placeID) {
+			t.Errorf("%s: place_id = %q, want %s", tt.target, got, tt.placeID)
+		}
+	}
+}
+
+func TestPointInHoleDoesNotMatchDonut(t *testing.T) {
+	outer := [][]float64{{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 0}}
+	hole := [][]float64{{1, 1}, {1, 3}, {3, 3}, {3, 1}, {1, 1}}
+	donut := Feature{
+		Properties: Properties{Name: "Donut", Id: "donut"},
+		Geometry:   Geometry{Type: "Polygon", Polygons: []Polygon{{outer, hole}}},
+	}
+	enclosing := Feature{
+		Properties: Properties{Name: "Enclosing", Id: "enclosing"},
+		Geometry:   Geometry{Type: "Polygon", Polygons: []Polygon{{{{-1, -1}, {5, -1}, {5, 5}, {-1, 5}, {-1, -1}}}}},
+	}
+
+	g := NewGeocoder(FeatureCollection{Type: "FeatureCollection", Features: []Feature{donut}})
+	if name, _, ok, _ := g.Lookup(0.5, 2); !ok || name != "Donut" {
+		t.Errorf("Lookup(ring) = %q, %v; want Donut", name, ok)
+	}
+	if name, _, ok, _ := g.Lookup(2, 2); ok {
+		t.Errorf("Lookup(hole) = %q, want no match", name)
+	}
+
+	g = NewGeocoder(FeatureCollection{Type: "FeatureCollection", Features: []Feature{donut, enclosing}})
+	if name, _, ok, _ := g.Lookup(0.5, 2); !ok || name != "Donut" {
+		t.Errorf("Lookup(ring) = %q, %v; want Donut", name, ok)
+	}
+	if name, _, ok, _ := g.Lookup(2, 2); !ok || name != "Enclosing" {
+		t.Errorf("Lookup(hole) = %q, %v; want the enclosing area", name, ok)
+	}
+}
+
+// benchmarkLookup10k is the 10k-feature dataset of the linear and indexed
+// lookup benchmarks, and a point in a feature near its middle.
+func benchmarkLookup10k(b *testing.B) (*areaSet, float64, float64) {
+	const rows, cols, cell = 100, 100, 0.01
+	g, err := NewGeocoderFromFile(writeSyntheticAreas(b, rows, cols, cell, 8))
+	if err != nil {
+		b.Fatal(err)
+	}
+	mid := rows*cols/2 + cols/2
+	return g.currentAreas(), (float64(mid%cols) + 0.5) * cell, (float64(mid/cols) + 0.5) * cell
+}
+
+func BenchmarkFindAreaLinear(b *testing.B) {
+	set, lng, lat := benchmarkLookup10k(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var match *area
+		for j := range set.areas {
+			if feature := &set.areas[j]; (match == nil || feature.size < match.size) && feature.contains(lng, lat) {
+				match = feature
+			}
+		}
+		if match == nil {
+			b.Fatal("linear scan found no area")
+		}
+	}
+}
+
+func BenchmarkFindAreaIndexed(b *testing.B) {
+	set, lng, lat := benchmarkLookup10k(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if lookupFeature(set, lng, lat) == nil {
+			b.Fatal("indexed lookup found no area")
+		}
+	}
+}
+
+func TestSharedBoundaryBelongsToOneArea(t *testing.T) {
+	// A 2×2 grid of unit squares. Points on the south and west edges are
+	// inside, so every point on a shared edge or vertex belongs to the
+	// square to its north and east.
+	featureCollection := FeatureCollection{Type: "FeatureCollection"}
+	for _, corner := range [][2]float64{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		lng, lat := corner[0], corner[1]
+		featureCollection.Features = append(featureCollection.Features, Feature{
+			Properties: Properties{Name: fmt.Sprintf("%v,%v", lng, lat), Id: fmt.Sprintf("%v,%v", lng, lat)},
+			Geometry: Geometry{Type: "Polygon", Polygons: []Polygon{{{
+				{lng, lat}, {lng + 1, lat}, {lng + 1, lat + 1}, {lng, lat + 1}, {lng, lat},
+			}}}},
+		})
+	}
+	g := NewGeocoder(featureCollection)
+
+	tests := []struct {
+		name     string
+		lng, lat float64
+		want     string
+	}{
+		{"shared vertical edge", 1, 0.5, "1,0"},
+		{"shared horizontal edge", 0.5, 1, "0,1"},
+		{"shared vertex", 1, 1, "1,1"},
+		{"outer south-west vertex", 0, 0, "0,0"},
+		{"outer west edge", 0, 1.5, "0,1"},
+		{"outer south edge", 1.5, 0, "1,0"},
+		{"outer east edge", 2, 0.5, ""},
+		{"outer north edge", 0.5, 2, ""},
+		{"outer north-east vertex", 2, 2, ""},
+	}
+	for _, tt := range tests {
+		matches := g.findAllFeatures(tt.lng, tt.lat)
+		var ids []string
+		for _, feature := range matches {
+			ids = append(ids, feature.Properties.Id)
+		}
+		if tt.want == "" && len(ids) != 0 || tt.want != "" && (len(ids) != 1 || ids[0] != tt.want) {
+			t.Errorf("%s (%v, %v): matched %v, want %q", tt.name, tt.lng, tt.lat, ids, tt.want)
+		}
+	}
+}
+
+// antimeridianFeature is a rectangle from longitude 179 to -178 that
+// straddles the antimeridian, centered at -179.5.
+func antimeridianFeature() Feature {
+	return Feature{
+		Properties: Properties{Name: "Date Line", Id: "date_line"},
+		Geometry: Geometry{Type: "Polygon", Polygons: []Polygon{{{
+			{179, -1}, {-178, -1}, {-178, 1}, {179, 1}, {179, -1},
+		}}}},
+	}
+}
+
+func TestAntimeridianArea(t *testing.T) {
+	g := NewGeocoder(FeatureCollection{Type: "FeatureCollection", Features: []Feature{antimeridianFeature()}})
+	for _, lng := range []float64{179.5, -179.5} {
+		if name, _, ok, _ := g.Lookup(lng, 0); !ok || name != "Date Line" {
+			t.Errorf("Lookup(%v, 0) = %q, %v; want Date Line", lng, name, ok)
+		}
+	}
+	if name, _, ok, _ := g.Lookup(0, 0); ok {
+		t.Errorf("Lookup(0, 0) = %q, want no match", name)
+	}
+
+	recorder := httptest.NewRecorder()
+	g.geocodeHandler(recorder, httptest.NewRequest(http.MethodGet, "/geocode?lat=0&lng=-179.5&viewport=true", nil))
+	var response GeocodeResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || len(response.Results) != 1 {
+		t.Fatalf("geocode = %s", recorder.Body.String())
+	}
+	result := response.Results[0]
+	if result.AreaCenter == nil || math.Abs(result.AreaCenter.Lng+179.5) > 1e-9 {
+		t.Errorf("area_center = %+v, want longitude -179.5", result.AreaCenter)
+	}
+	if viewport := result.Geometry.Viewport; viewport == nil || viewport.Southwest.Lng != 179 || viewport.Northeast.Lng != -178 {
+		t.Errorf("viewport = %+v, want southwest lng 179 and northeast lng -178", viewport)
+	}
+}
+
+func TestAreaNamesAreEscaped(t *testing.T) {
+	const name = `O'Neil "Central" \ Zone`
+	g := NewGeocoder(FeatureCollection{Type: "FeatureCollection", Features: []Feature{{
+		Properties: Properties{Name: name, Id: "oneil"},
+		Geometry:   Geometry{Type: "Polygon", Polygons: []Polygon{{unitSquare}}},
+	}}})
+	recorder := httptest.NewRecorder()
+	g.geocodeHandler(recorder, httptest.NewRequest(http.MethodGet, "/geocode?lat=0.5&lng=0.5", nil))
+
+	var response GeocodeResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("response is not valid JSON: %v\n%s", err, recorder.Body.String())
+	}
+	if len(response.Results) != 1 || response.Results[0].FormattedAddress != name {
+		t.Errorf("results = %+v, want formatted_address %q", response.Results, name)
+	}
+}
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	go server.Serve(listener)
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		response, err := http.Get("http://" + addr)
+		if err != nil {
+			t.Errorf("in-flight request: %v", err)
+			close(responses)
+			return
+		}
+		response.Body.Close()
+		responses <- response
+	}()
+	<-entered
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- shutdownServers([]*http.Server{server}, 5*time.Second) }()
+
+	// Once shutdown has closed the listener, new connections are refused
+	// while the in-flight request is still running.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("server still accepting connections after shutdown began")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	close(release)
+	if response, ok := <-responses; ok && response.StatusCode != http.StatusOK {
+		t.Errorf("in-flight request status = %d, want %d", response.StatusCode, http.StatusOK)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("shutdownServers = %v", err)
+	}
+}
+
+func TestDistanceToBoundary(t *testing.T) {
+	square := [][]float64{{0, 0}, {0.01, 0}, {0.01, 0.01}, {0, 0.01}, {0, 0}}
+	g := NewGeocoder(FeatureCollection{Type: "FeatureCollection", Features: []Feature{{
+		Properties: Properties{Name: "Square", Id: "square"},
+		Geometry:   Geometry{Type: "Polygon", Polygons: []Polygon{{square}}},
+	}}})
+
+	// 0.002° east of the west edge, well away from the other three.
+	lng, lat := 0.002, 0.005
+	want := haversineMeters(lng, lat, 0, lat)
+	if got := distanceToPolygon(lng, lat, square); math.Abs(got-want) > 0.01 {
+		t.Errorf("distanceToPolygon = %v, want %v", got, want)
+	}
+
+	recorder := httptest.NewRecorder()
+	g.geocodeHandler(recorder, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/geocode?lat=%v&lng=%v&distance=true", lat, lng), nil))
+	var response GeocodeResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || len(response.Results) != 1 {
+		t.Fatalf("geocode = %s", recorder.Body.String())
+	}
+	if got := response.Results[0].DistanceMeters; got == nil || math.Abs(*got-want) > 0.01 {
+		t.Errorf("distance_meters = %v, want %v", got, want)
+	}
+}
+
+func TestMalformedFeaturesAreSkipped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "areas.json")
+	data := `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"name":"Empty","id":"empty"},
+		 "geometry":{"type":"Polygon","coordinates":[[]]}},
+		{"type":"Feature","properties":{"name":"Degenerate","id":"degenerate"},
+		 "geometry":{"type":"Polygon","coordinates":[[[0,0],[1,1]]]}},
+		{"type":"Feature","properties":{"name":"Null","id":"null"},"geometry":null},
+		{"type":"Feature","properties":{"name":"Flat","id":"flat"},
+		 "geometry":{"type":"Polygon","coordinates":[[0,0],[1,0],[1,1],[0,0]]}},
+		{"type":"Feature","properties":{"name":"Deep","id":"deep"},
+		 "geometry":{"type":"MultiPolygon","coordinates":[[[[[0,0]]]]]}},
+		{"type":"Feature","properties":{"name":"Road","id":"road"},
+		 "geometry":{"type":"LineString","coordinates":[[0,0],[1,1]]}},
+		{"type":"Feature","properties":{"name":"Square","id":"square"},
+		 "geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}}]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewGeocoderFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, feature := range g.currentAreas().areas {
+		ids = append(ids, feature.Properties.Id)
+	}
+	if !reflect.DeepEqual(ids, []string{"square"}) {
+		t.Errorf("loaded areas %v, want only square", ids)
+	}
+	if name, _, ok, _ := g.Lookup(0.5, 0.5); !ok || name != "Square" {
+		t.Errorf("Lookup = %q, %v; want Square", name, ok)
+	}
+}
+
+func TestNestedAreaPrefersSmallest(t *testing.T) {
+	big := Feature{
+		Properties: Properties{Name: "County", Id: "county"},
+		Geometry:   Geometry{Type: "Polygon", Polygons: []Polygon{{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}}}},
+	}
+	small := Feature{
+		Properties: Properties{Name: "Town", Id: "town"},
+		Geometry:   Geometry{Type: "Polygon", Polygons: []Polygon{{{{4, 4}, {6, 4}, {6, 6}, {4, 6}, {4, 4}}}}},
+	}
+	// Both file orders, so that the smaller area wins by size rather than
+	// by coming first.
+	for _, features := range [][]Feature{{big, small}, {small, big}} {
+		g := NewGeocoder(FeatureCollection{Type: "FeatureCollection", Features: features})
+		for _, point := range [][2]float64{{5, 5}, {4.5, 5.5}} {
+			if name, _, ok, _ := g.Lookup(point[0], point[1]); !ok || name != "Town" {
+				t.Errorf("first %s: Lookup(%v) = %q, %v; want Town", features[0].Properties.Id, point, name, ok)
+			}
+		}
+		for _, point := range [][2]float64{{1, 1}, {9, 5}, {5, 7}} {
+			if name, _, ok, _ := g.Lookup(point[0], point[1]); !ok || name != "County" {
+				t.Errorf("first %s: Lookup(%v) = %q, %v; want County", features[0].Properties.Id, point, name, ok)
+			}
+		}
+	}
+}
+
+func TestRecoverPanicsAnswersAndKeepsServing(t *testing.T) {
+	panics := true
+	handler := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if panics {
+			panic("boom")
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "OK"})
+	}), requestID, recoverPanics)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/geocode", nil))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusInternalServerError)
+	}
+	var body ErrorResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %q is not JSON: %v", recorder.Body, err)
+	}
+	if body.Status != "ERROR" || body.ErrorMessage != "Internal server error" {
+		t.Errorf("body = %+v, want the internal server error", body)
+	}
+
+	panics = false
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/geocode", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("status after the panic = %d, want %d", recorder.Code, http.StatusOK)
+	}
+}
+
+func TestGeocodePrecisionAndDecimals(t *testing.T) {
+	g := NewGeocoder(FeatureCollection{Type: "FeatureCollection", Features: []Feature{{
+		Properties: Properties{Name: "Square", Id: "square"},
+		Geometry:   Geometry{Type: "Polygon", Polygons: []Polygon{{unitSquare}}},
+	}}})
+	geocode := func(query string) Result {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		g.geocodeHandler(recorder, httptest.NewRequest(http.MethodGet, "/geocode?lat=0.123456789&lng=0.987654321&"+query, nil))
+		var response GeocodeResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || len(response.Results) == 0 {
+			t.Fatalf("%s: status %d, body %s", query, recorder.Code, recorder.Body)
+		}
+		return response.Results[0]
+	}
+
+	// precision is the geohash length, as it has always been; decimals
+	// rounds the coordinates.
+	if result := geocode("precision=5"); len(result.Geohash) != 5 || result.Geometry.Location.Lat != 0.123457 {
+		t.Errorf("precision=5: geohash %q, lat %v; want 5 characters and 6 decimal places", result.Geohash, result.Geometry.Location.Lat)
+	}
+	if result := geocode("decimals=2"); len(result.Geohash) != defaultGeohashPrecision || result.Geometry.Location.Lat != 0.12 {
+		t.Errorf("decimals=2: geohash %q, lat %v; want %d characters and 2 decimal places", result.Geohash, result.Geometry.Location.Lat, defaultGeohashPrecision)
+	}
+
+	recorder := httptest.NewRecorder()
+	g.geocodeHandler(recorder, httptest.NewRequest(http.MethodGet, "/geocode?lat=0.5&lng=0.5&precision=13", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("precision=13: status %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAntimeridianAreaWKT(t *testing.T) {
+	g := NewGeocoder(FeatureCollection{Type: "FeatureCollection", Features: []Feature{antimeridianFeature()}})
+	recorder := httptest.NewRecorder()
+	g.geocodeHandler(recorder, httptest.NewRequest(http.MethodGet, "/geocode?lat=0&lng=179.5&format=wkt", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+	// Winding is normalized before the ring is shifted, and unshifted the
+	// ring looks clockwise, so it comes back reversed.
+	want := "POLYGON((179 -1,179 1,-178 1,-178 -1,179 -1))"
+	if got := strings.TrimSpace(recorder.Body.String()); got != want {
+		t.Errorf("wkt = %s, want %s", got, want)
+	}
+}
+
+func TestContinentSizedAreasStayOffTheGrid(t *testing.T) {
+	continent := Feature{
+		Properties: Properties{Name: "Continent", Id: "continent"},
+		Geometry:   Geometry{Type: "Polygon", Polygons: []Polygon{{{{-20, -35}, {50, -35}, {50, 35}, {-20, 35}, {-20, -35}}}}},
+	}
+	// Parts on both sides of ±180, neither crossing it, so the bounding box
+	// is not shifted and spans almost the whole globe.
+	fiji := Feature{
+		Properties: Properties{Name: "Fiji", Id: "fiji"},
+		Geometry: Geometry{Type: "MultiPolygon", Polygons: []Polygon{
+			{{{177, -19}, {179, -19}, {179, -16}, {177, -16}, {177, -19}}},
+			{{{-180, -17}, {-179, -17}, {-179, -16}, {-180, -16}, {-180, -17}}},
+		}},
+	}
+	town := Feature{
+		Properties: Properties{Name: "Town", Id: "town"},
+		Geometry:   Geometry{Type: "Polygon", Polygons: []Polygon{{{{10, 10}, {10.02, 10}, {10.02, 10.02}, {10, 10.02}, {10, 10}}}}},
+	}
+
+	idx := NewSpatialIndex(gridCellSize)
+	for _, feature := range []Feature{continent, fiji, town} {
+		idx.Insert(feature)
+	}
+	if len(idx.cells) > maxCellsPerArea {
+		t.Errorf("index has %d cells, want the large areas kept off the grid", len(idx.cells))
+	}
+	for _, tt := range []struct {
+		lng, lat float64
+		want     []string
+	}{
+		{10.01, 10.01, []string{"continent", "town"}},
+		{0, -17, []string{"continent", "fiji"}},
+	} {
+		var ids []string
+		for _, feature := range idx.Query(tt.lng, tt.lat) {
+			ids = append(ids, feature.Properties.Id)
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("Query(%v, %v) = %v, want %v", tt.lng, tt.lat, ids, tt.want)
+		}
+	}
+
+	g := NewGeocoder(FeatureCollection{Type: "FeatureCollection", Features: []Feature{continent, fiji, town}})
+	for _, tt := range []struct {
+		lng, lat float64
+		want     string
+	}{
+		{10.01, 10.01, "Town"},
+		{0, 0, "Continent"},
+		{178, -18, "Fiji"},
+		{-179.5, -16.5, "Fiji"},
+	} {
+		if name, _, ok, _ := g.Lookup(tt.lng, tt.lat); !ok || name != tt.want {
+			t.Errorf("Lookup(%v, %v) = %q, %v; want %s", tt.lng, tt.lat, name, ok, tt.want)
+		}
+	}
+	if name, _, ok, _ := g.Lookup(100, -17); ok {
+		t.Errorf("Lookup(100, -17) = %q, want no match between Fiji's parts", name)
+	}
+}
+
+func TestForgedForwardedForIsRateLimited(t *testing.T) {
+	limiter := newIPRateLimiter(1, 1, true)
+	handler := limiter.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	request := func(forwarded string, remote string) int {
+		r := httptest.NewRequest(http.MethodGet, "/geocode", nil)
+		r.RemoteAddr = remote + ":1234"
+		r.Header.Set("X-Forwarded-For", forwarded)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		return recorder.Code
+	}
+
+	// The proxy appends the real client, 203.0.113.7, after whatever the
+	// client sent; rotating the forged leftmost entry must not help.
+	if code := request("198.51.100.1, 203.0.113.7", "10.0.0.1"); code != http.StatusOK {
+		t.Fatalf("first request: status %d, want %d", code, http.StatusOK)
+	}
+	if code := request("198.51.100.2, 203.0.113.7", "10.0.0.1"); code != http.StatusTooManyRequests {
+		t.Errorf("forged leftmost entry: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := request("203.0.113.8", "10.0.0.1"); code != http.StatusOK {
+		t.Errorf("another client: status %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestBodyPointsMissingCoordinatesAreRejected(t *testing.T) {
+	g := NewGeocoder(FeatureCollection{Type: "FeatureCollection", Features: []Feature{{
+		Properties: Properties{Name: "Square", Id: "square"},
+		Geometry:   Geometry{Type: "Polygon", Polygons: []Polygon{{unitSquare}}},
+	}}})
+	tests := []struct {
+		handler http.HandlerFunc
+		target  string
+		body    string
+		status  int
+	}{
+		{g.batchGeocodeHandler, "/geocode/batch", `[{"lat":0.5,"lng":0.5},{"lat":0.5}]`, http.StatusBadRequest},
+		{g.batchGeocodeHandler, "/geocode/batch", `[{}]`, http.StatusBadRequest},
+		{g.batchGeocodeHandler, "/geocode/batch", `[{"lat":0,"lng":0}]`, http.StatusOK},
+		{g.routeHandler, "/route", `[{"lat":0.5,"lng":0.5},{"lng":0.5}]`, http.StatusBadRequest},
+		{g.routeHandler, "/route", `[{"lat":0.5,"lng":0.5},{"lat":0,"lng":0}]`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/json")
+		tt.handler(recorder, r)
+		if recorder.Code != tt.status {
+			t.Errorf("%s %s: status = %d, want %d: %s", tt.target, tt.body, recorder.Code, tt.status, recorder.Body)
+		}
+		if tt.status == http.StatusBadRequest && !strings.Contains(recorder.Body.String(), "Missing lat or lng") {
+			t.Errorf("%s %s: body = %s, want a missing lat or lng error", tt.target, tt.body, recorder.Body)
+		}
+	}
+}
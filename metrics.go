@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Result label values for geocodeRequests.
+const (
+	resultHit      = "hit"
+	resultFallback = "fallback"
+	resultError    = "error"
+)
+
+var (
+	geocodeRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "geomocker_geocode_requests_total",
+		Help: "Geocode requests by result: hit, fallback (no area matched) or error.",
+	}, []string{"result"})
+
+	geocodeLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "geomocker_geocode_duration_seconds",
+		Help:    "Time spent handling geocode requests.",
+		Buckets: prometheus.ExponentialBuckets(0.00005, 4, 8),
+	})
+
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "geomocker_area_cache_lookups_total",
+		Help: "Area cache lookups by result: hit or miss.",
+	}, []string{"result"})
+)
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Middleware wraps a handler with a cross-cutting concern.
+type Middleware func(http.Handler) http.Handler
+
+// chain wraps h so that the first middleware is the outermost.
+func chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// requestIDHeader carries the ID that correlates a request across services.
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// requestID takes the request's X-Request-ID, or generates a random UUID if
+// it has none, stores it in the request context and echoes it back in the
+// response header.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			id = newUUID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestIDFromContext returns the ID stored by the requestID middleware, or
+// "" outside of a request.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// recoverPanics turns a panicking handler into a 500 response instead of
+// letting net/http drop the connection.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			slog.Error("Handler panic", "request_id", requestIDFromContext(r.Context()), "method", r.Method, "path", r.URL.Path, "err", err, "stack", string(debug.Stack()))
+			writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedOrigins is the set of origins the cors middleware echoes back in
+// Access-Control-Allow-Origin. The special entry "*" allows any origin.
+var allowedOrigins = map[string]bool{}
+
+// parseOrigins splits a comma-separated -allowed-origins value.
+func parseOrigins(list string) map[string]bool {
+	origins := map[string]bool{}
+	for _, origin := range strings.Split(list, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins[origin] = true
+		}
+	}
+	return origins
+}
+
+func setCORSHeaders(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	switch {
+	case allowedOrigins["*"]:
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	case origin != "" && allowedOrigins[origin]:
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	default:
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+	if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		w.Header().Set("Access-Control-Allow-Headers", headers)
+	}
+}
+
+// cors sets the CORS headers on every response and answers preflight
+// requests directly.
+func cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setCORSHeaders(w, r)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests logs one line per request at info level.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		slog.Info("request",
+			"request_id", requestIDFromContext(r.Context()),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"query", r.URL.RawQuery,
+			"status", recorder.status,
+			"latency", time.Since(start),
+		)
+	})
+}
+
+// gzipMinSize is the smallest response body worth compressing.
+const gzipMinSize = 1024
+
+// gzipResponses compresses response bodies of at least gzipMinSize bytes for
+// clients that accept gzip. Smaller bodies are sent as-is.
+func gzipResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(gw, r)
+		// Not deferred: if the handler panics, the buffered body is dropped
+		// so recoverPanics can still send a clean 500.
+		gw.Close()
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(coding) != "gzip" {
+			continue
+		}
+		return strings.ReplaceAll(params, " ", "") != "q=0"
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the first gzipMinSize bytes of a response
+// to decide whether compressing it is worthwhile.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	buf         []byte
+	gz          *gzip.Writer
+	passthrough bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	switch {
+	case w.gz != nil:
+		return w.gz.Write(p)
+	case w.passthrough:
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if len(w.buf) < gzipMinSize {
+		return len(p), nil
+	}
+
+	// Handlers that encode their own body (e.g. promhttp) are left alone.
+	if w.Header().Get("Content-Encoding") != "" {
+		w.passthrough = true
+		return len(p), w.flushRaw()
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	if _, err := w.gz.Write(w.buf); err != nil {
+		return 0, err
+	}
+	w.buf = nil
+	return len(p), nil
+}
+
+func (w *gzipResponseWriter) flushRaw() error {
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+// Close finishes the compressed stream, or sends a small body uncompressed.
+func (w *gzipResponseWriter) Close() error {
+	switch {
+	case w.gz != nil:
+		return w.gz.Close()
+	case w.passthrough:
+		return nil
+	case !w.wroteHeader:
+		// The handler wrote nothing at all; let net/http send its default.
+		return nil
+	}
+	return w.flushRaw()
+}
+
+// jsonpCallbackPattern accepts plain or dotted JavaScript identifiers, which
+// is all a callback needs; anything else could inject script.
+var jsonpCallbackPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+// maxJSONPCallbackLength caps the length of ?callback=.
+const maxJSONPCallbackLength = 64
+
+// jsonp wraps JSON responses in ?callback=name(...) for legacy clients that
+// can only load script, serving them as application/javascript. Requests
+// with an unsafe callback name are rejected with 400, and responses that are
+// not JSON are passed through untouched.
+func jsonp(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callback := r.URL.Query().Get("callback")
+		if callback == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(callback) > maxJSONPCallbackLength || !jsonpCallbackPattern.MatchString(callback) {
+			writeJSONError(w, http.StatusBadRequest, "Invalid callback parameter")
+			return
+		}
+
+		jw := &jsonpResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(jw, r)
+		jw.finish(callback)
+	})
+}
+
+// jsonpResponseWriter buffers a JSON body so it can be wrapped once the
+// handler is done; other bodies are written straight through.
+type jsonpResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	passthrough bool
+	buf         []byte
+}
+
+func (w *jsonpResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.status, w.wroteHeader = status, true
+	mediaType, _, _ := strings.Cut(w.Header().Get("Content-Type"), ";")
+	isJSON := mediaType == "application/json" || mediaType == "application/geo+json"
+	if !isJSON || status == http.StatusNotModified || status == http.StatusNoContent {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *jsonpResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+func (w *jsonpResponseWriter) finish(callback string) {
+	if w.passthrough || !w.wroteHeader {
+		return
+	}
+	w.Header().Set("Content-Type", "application/javascript")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	// The leading comment keeps the body from starting with bytes the
+	// client controls.
+	fmt.Fprintf(w.ResponseWriter, "/**/%s(%s);", callback, bytes.TrimSpace(w.buf))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+//go:embed static/index.html static/openapi.json
+var static embed.FS
+
+// rootHandler is the catch-all route. It serves the map page for a bare
+// GET /, keeps answering geocode queries sent to / by older clients, and
+// returns a JSON 404 for every other path.
+func (g *Geocoder) rootHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeJSONError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if r.Method != http.MethodGet || r.URL.RawQuery != "" {
+		g.geocodeHandler(w, r)
+		return
+	}
+
+	page, err := static.ReadFile("static/index.html")
+	if err != nil {
+		slog.Error("Error reading map page", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
+
+// openapiHandler serves the OpenAPI 3 description of the geocode, batch,
+// areas and health endpoints. The document is maintained by hand in
+// static/openapi.json; tests check its schemas against the response types.
+func openapiHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	spec, err := static.ReadFile("static/openapi.json")
+	if err != nil {
+		slog.Error("Error reading OpenAPI document", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	writeJSON(w, http.StatusOK, json.RawMessage(spec))
+}
@@ -0,0 +1,44 @@
+package main
+
+import "fmt"
+
+// decodePolyline decodes a Google encoded polyline with 5 decimal places of
+// precision. Each position is stored as a lat then lng delta from the
+// previous one, zigzag encoded and split into 5-bit chunks offset by 63.
+func decodePolyline(s string) ([]Point, error) {
+	var points []Point
+	lat, lng := 0, 0
+	for i := 0; i < len(s); {
+		var deltas [2]int
+		for d := range deltas {
+			result, shift := 0, 0
+			for {
+				if i >= len(s) {
+					return nil, fmt.Errorf("truncated polyline at position %d", len(points))
+				}
+				b := int(s[i]) - 63
+				i++
+				if b < 0 || b > 0x3f {
+					return nil, fmt.Errorf("invalid polyline character %q at offset %d", s[i-1], i-1)
+				}
+				result |= (b & 0x1f) << shift
+				shift += 5
+				if b < 0x20 {
+					break
+				}
+				if shift > 30 {
+					return nil, fmt.Errorf("polyline value too long at position %d", len(points))
+				}
+			}
+			if result&1 != 0 {
+				deltas[d] = ^(result >> 1)
+			} else {
+				deltas[d] = result >> 1
+			}
+		}
+		lat += deltas[0]
+		lng += deltas[1]
+		points = append(points, Point{Lng: float64(lng) / 1e5, Lat: float64(lat) / 1e5})
+	}
+	return points, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiterIdleTimeout is how long a client's limiter is kept after its last
+// request. An evicted client simply starts again with a full bucket.
+const limiterIdleTimeout = 3 * time.Minute
+
+// ipRateLimiter keeps one token bucket per client IP.
+type ipRateLimiter struct {
+	limit rate.Limit
+	burst int
+	// trustForwardedFor makes clientIP use X-Forwarded-For. Only enable it
+	// behind a proxy that sets the header, since clients can forge it.
+	trustForwardedFor bool
+
+	mu      sync.Mutex
+	clients map[string]*clientLimiter
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newIPRateLimiter returns a limiter allowing each client perSecond requests
+// per second with bursts of up to burst. It evicts idle clients in the
+// background for the life of the process.
+func newIPRateLimiter(perSecond float64, burst int, trustForwardedFor bool) *ipRateLimiter {
+	l := &ipRateLimiter{
+		limit:             rate.Limit(perSecond),
+		burst:             burst,
+		trustForwardedFor: trustForwardedFor,
+		clients:           map[string]*clientLimiter{},
+	}
+	go func() {
+		for range time.Tick(limiterIdleTimeout / 3) {
+			l.evict(time.Now().Add(-limiterIdleTimeout))
+		}
+	}()
+	return l
+}
+
+// get returns the limiter for ip, creating it if needed.
+func (l *ipRateLimiter) get(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	client, ok := l.clients[ip]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = client
+	}
+	client.lastSeen = time.Now()
+	return client.limiter
+}
+
+// evict drops every client not seen since cutoff.
+func (l *ipRateLimiter) evict(cutoff time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for ip, client := range l.clients {
+		if client.lastSeen.Before(cutoff) {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// clientIP returns the address requests are limited by: the last
+// X-Forwarded-For entry when trusted, otherwise the connection's remote IP.
+// The last entry is the one the trusted proxy appended; every earlier one
+// came from the client, which can put anything there.
+func (l *ipRateLimiter) clientIP(r *http.Request) string {
+	if l.trustForwardedFor {
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			entries := strings.Split(values[len(values)-1], ",")
+			if ip := strings.TrimSpace(entries[len(entries)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// middleware rejects requests over the client's rate with 429 and a
+// Retry-After header giving the whole seconds until a token is available.
+func (l *ipRateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reservation := l.get(l.clientIP(r)).Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			retryAfter := 1
+			if reservation.OK() {
+				retryAfter = int(math.Ceil(delay.Seconds()))
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeJSONError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
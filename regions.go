@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// regionPrefix is the path under which each -regions dataset is served:
+// region "kenya" answers /region/kenya/geocode, /region/kenya/healthz and so
+// on, independently of the default dataset at the root.
+const regionPrefix = "/region/"
+
+var regionNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseRegions parses a -regions value, comma-separated name=path pairs,
+// into a map from region name to areas path.
+func parseRegions(list string) (map[string]string, error) {
+	regions := map[string]string{}
+	for _, entry := range parseList(list) {
+		name, path, ok := strings.Cut(entry, "=")
+		name, path = strings.TrimSpace(name), strings.TrimSpace(path)
+		switch {
+		case !ok || path == "":
+			return nil, fmt.Errorf("region %q: expected name=path", entry)
+		case !regionNamePattern.MatchString(name):
+			return nil, fmt.Errorf("region %q: names may only contain letters, digits, - and _", name)
+		case regions[name] != "":
+			return nil, fmt.Errorf("region %q listed twice", name)
+		}
+		regions[name] = path
+	}
+	return regions, nil
+}
+
+// formatRegions is the inverse of parseRegions, in name order.
+func formatRegions(regions map[string]string) string {
+	entries := make([]string, 0, len(regions))
+	for name, path := range regions {
+		entries = append(entries, name+"="+path)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+// registerDataset registers the endpoints of one dataset on mux, each under
+// prefix: "" for the default dataset, or regionPrefix plus the region name.
+// Under readOnly the mutating endpoints answer 403 instead.
+func registerDataset(mux *http.ServeMux, prefix string, g *Geocoder, readOnly bool) {
+	routes := map[string]http.HandlerFunc{
+		"/healthz":       g.healthzHandler,
+		"/areas":         g.areasHandler,
+		"/sameArea":      g.sameAreaHandler,
+		"/snap":          g.snapHandler,
+		"/nearest":       g.nearestHandler,
+		"/nearestPOI":    g.nearestPOIHandler,
+		"/coverage":      g.coverageHandler,
+		"/route":         g.routeHandler,
+		"/search":        g.searchHandler,
+		"/debug/point":   g.debugPointHandler,
+		"/geocode":       g.geocodeHandler,
+		"/geocode/batch": g.batchGeocodeHandler,
+		"/geocode/wkt":   g.wktGeocodeHandler,
+		"/areas.geojson": g.areasGeoJSONHandler,
+	}
+	for pattern, h := range routes {
+		mux.HandleFunc(prefix+pattern, h)
+	}
+
+	// Mutating endpoints change server state and are refused in -readonly
+	// deployments.
+	mutating := map[string]http.HandlerFunc{
+		"/reload": g.reloadHandler,
+	}
+	for pattern, h := range mutating {
+		if readOnly {
+			slog.Info("Read-only: refusing mutating endpoint", "path", prefix+pattern)
+			h = readOnlyHandler
+		}
+		mux.HandleFunc(prefix+pattern, h)
+	}
+}
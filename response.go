@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"net/http"
+)
+
+// GeocodeResponse mirrors the top level of a Google Geocoding API response.
+type GeocodeResponse struct {
+	Results []Result `json:"results"`
+	Status  string   `json:"status"`
+}
+
+type Result struct {
+	AddressComponents []AddressComponent `json:"address_components"`
+	FormattedAddress  string             `json:"formatted_address"`
+	Geometry          ResultGeometry     `json:"geometry"`
+	PlaceId           string             `json:"place_id"`
+	Types             []string           `json:"types"`
+
+	// Geohash encodes the result's location, defaultGeohashPrecision
+	// characters long unless the request set ?precision=.
+	Geohash string `json:"geohash"`
+
+	// AreaCenter is the centroid of the matched area. It is omitted for
+	// the fallback locality.
+	AreaCenter *Location `json:"area_center,omitempty"`
+
+	// AreaKm2 is the size of the matched area in square kilometers. It is
+	// omitted for the fallback locality.
+	AreaKm2 *float64 `json:"area_km2,omitempty"`
+
+	// DistanceMeters is the distance from the query point to the nearest
+	// edge of the matched area, when requested with ?distance=true.
+	DistanceMeters *float64 `json:"distance_meters,omitempty"`
+
+	// Containment is "inside", "boundary" or "outside", when requested with
+	// ?classify=true.
+	Containment string `json:"containment,omitempty"`
+
+	// Properties are all of the matched feature's GeoJSON properties, when
+	// requested with ?props=true.
+	Properties map[string]any `json:"properties,omitempty"`
+
+	// Debug locates the point within the matched geometry, when requested
+	// with ?debug=true.
+	Debug *ResultDebug `json:"debug,omitempty"`
+}
+
+// ResultDebug identifies the part of a feature's geometry that contained
+// the query point. Polygon indexes the MultiPolygon's polygons (always 0 for
+// a Polygon) and Ring the ring within it; as holes exclude points, the
+// containing ring is always the outer ring 0.
+type ResultDebug struct {
+	Polygon int `json:"polygon"`
+	Ring    int `json:"ring"`
+}
+
+type AddressComponent struct {
+	LongName  string   `json:"long_name"`
+	ShortName string   `json:"short_name"`
+	Types     []string `json:"types"`
+}
+
+type ResultGeometry struct {
+	Location     Location `json:"location"`
+	LocationType string   `json:"location_type"`
+
+	// Viewport is the bounding box of the matched area, when requested with
+	// ?viewport=true.
+	Viewport *Viewport `json:"viewport,omitempty"`
+}
+
+// Viewport mirrors Google's geometry.viewport.
+type Viewport struct {
+	Northeast Location `json:"northeast"`
+	Southwest Location `json:"southwest"`
+}
+
+// newViewport returns the viewport of b. Longitudes of areas shifted across
+// the antimeridian are wrapped back into [-180, 180], so Southwest.Lng may
+// exceed Northeast.Lng as in Google's responses.
+func newViewport(b bbox) *Viewport {
+	return &Viewport{
+		Northeast: Location{Lat: b.MaxLat, Lng: wrapLng(b.MaxLng)},
+		Southwest: Location{Lat: b.MinLat, Lng: wrapLng(b.MinLng)},
+	}
+}
+
+type Location struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+var localityTypes = []string{"locality", "political"}
+
+// fallbackLocality is the locality that contains every area. It is returned
+// for points outside all areas and appended to area names in long_name. When
+// empty, such points get ZERO_RESULTS instead of a made-up locality.
+var fallbackLocality = "Dire Dawa"
+
+// fallbackTypes are the types of the fallback locality result.
+var fallbackTypes = localityTypes
+
+// strictMode makes single-point reverse geocodes of a point outside every
+// area return ZERO_RESULTS instead of the fallback locality, as a request
+// with ?strict=true does. Multi-point responses keep one result per point and
+// still use the fallback for unmatched points; the fallback's long_name
+// suffix on matched areas is unaffected.
+var strictMode = false
+
+// wantsZeroResults reports whether r should get ZERO_RESULTS rather than the
+// fallback locality for a point that matched no area.
+func wantsZeroResults(r *http.Request) bool {
+	return strictMode || fallbackLocality == "" || r.URL.Query().Get("strict") == "true"
+}
+
+// fallbackPlaceID returns a stable place_id for a point outside every area:
+// an FNV-1a hash of the point rounded to 6 decimal places, so that nearby
+// queries for the same spot share an id while distinct spots do not.
+func fallbackPlaceID(lat float64, lng float64) string {
+	key := newCacheKey(lng, lat)
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d,%d", key.lat, key.lng)
+	return fmt.Sprintf("fallback_%016x", h.Sum64())
+}
+
+// newResult builds a single reverse geocode result for the point located in
+// feature. A nil feature produces the fallback locality; with no
+// fallbackLocality that result has no address components.
+func newResult(feature *area, lat float64, lng float64) Result {
+	longName, shortName, placeId := fallbackLocality, fallbackLocality, fallbackPlaceID(lat, lng)
+	types := fallbackTypes
+	var center *Location
+	var areaKm2 *float64
+	if feature != nil {
+		name := feature.Properties.Name
+		longName, shortName, placeId = name, name, feature.Properties.Id
+		if fallbackLocality != "" {
+			longName += ", " + fallbackLocality
+		}
+		types = localityTypes
+		center = &Location{Lat: feature.center.Lat, Lng: feature.center.Lng}
+		size := feature.areaKm2
+		areaKm2 = &size
+	}
+
+	components := []AddressComponent{}
+	if shortName != "" {
+		components = append(components, AddressComponent{LongName: longName, ShortName: shortName, Types: types})
+	} else {
+		types = []string{}
+	}
+
+	return Result{
+		AddressComponents: components,
+		FormattedAddress:  shortName,
+		Geometry: ResultGeometry{
+			Location:     Location{Lat: lat, Lng: lng},
+			LocationType: "APPROXIMATE",
+		},
+		PlaceId:    placeId,
+		Types:      types,
+		Geohash:    geohashEncode(lat, lng, defaultGeohashPrecision),
+		AreaCenter: center,
+		AreaKm2:    areaKm2,
+	}
+}
+
+// defaultDecimals is the number of decimal places coordinates are rounded to
+// in responses unless a request sets ?decimals=. Requests are clamped to
+// [0, maxDecimals].
+const (
+	defaultDecimals = 6
+	maxDecimals     = 10
+)
+
+// roundLocations rounds the result's location, viewport and area center to
+// decimals decimal places. The geohash is left as computed from the full
+// point.
+func (r *Result) roundLocations(decimals int) {
+	r.Geometry.Location = r.Geometry.Location.rounded(decimals)
+	if r.Geometry.Viewport != nil {
+		r.Geometry.Viewport = &Viewport{
+			Northeast: r.Geometry.Viewport.Northeast.rounded(decimals),
+			Southwest: r.Geometry.Viewport.Southwest.rounded(decimals),
+		}
+	}
+	if r.AreaCenter != nil {
+		center := r.AreaCenter.rounded(decimals)
+		r.AreaCenter = &center
+	}
+}
+
+func (l Location) rounded(decimals int) Location {
+	scale := math.Pow(10, float64(decimals))
+	return Location{
+		Lat: math.Round(l.Lat*scale) / scale,
+		Lng: math.Round(l.Lng*scale) / scale,
+	}
+}
+
+// Content types of JSON responses. The charset is redundant for JSON, which
+// is always UTF-8, but some strict clients insist on it.
+const (
+	jsonContentType    = "application/json; charset=utf-8"
+	geoJSONContentType = "application/geo+json; charset=utf-8"
+)
+
+// writeJSON marshals v and writes it with the given status code. The
+// Content-Type defaults to jsonContentType unless the caller already set one.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Error marshalling response", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", jsonContentType)
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// writeGeoJSON is writeJSON for GeoJSON bodies.
+func writeGeoJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", geoJSONContentType)
+	writeJSON(w, status, v)
+}
+
+// ErrorResponse follows the shape of a Google Geocoding API error.
+type ErrorResponse struct {
+	Status       string `json:"status"`
+	ErrorMessage string `json:"error_message"`
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, ErrorResponse{Status: "ERROR", ErrorMessage: message})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is how long the areas path must stay quiet after a change
+// before it is reloaded, so that a file being rewritten is not read halfway.
+const watchDebounce = 500 * time.Millisecond
+
+// Watch reloads the areas whenever the file, or any areas file in the
+// directory, changes on disk. It watches the containing directory rather
+// than the file itself so that files replaced by a rename are still seen. A
+// file that fails to load is logged and the previous areas are kept.
+func (g *Geocoder) Watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	dir, name := filepath.Dir(g.path), filepath.Base(g.path)
+	if info, err := os.Stat(g.path); err == nil && info.IsDir() {
+		dir, name = g.path, ""
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				changed := filepath.Base(event.Name)
+				if (name != "" && changed != name) || (name == "" && !isAreasFile(changed)) {
+					continue
+				}
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				if timer == nil {
+					timer = time.AfterFunc(watchDebounce, g.autoReload)
+				} else {
+					timer.Reset(watchDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Error("Error watching areas", "path", g.path, "err", err)
+			}
+		}
+	}()
+	slog.Info("Watching areas for changes", "path", g.path)
+	return nil
+}
+
+func (g *Geocoder) autoReload() {
+	features, err := g.Reload()
+	if err != nil {
+		slog.Error("Error auto-reloading areas; keeping previous areas", "path", g.path, "err", err)
+		return
+	}
+	slog.Info("Auto-reloaded areas", "features", features, "path", g.path)
+}
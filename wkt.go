@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// polygonToWKT formats p as a WKT POLYGON, outer ring first and one
+// parenthesized ring per hole, with positions written lng lat. Longitudes
+// shifted past 180 for an area across the antimeridian are wrapped back.
+func polygonToWKT(p Polygon) string {
+	var b strings.Builder
+	b.WriteString("POLYGON")
+	writeWKTRings(&b, p)
+	return b.String()
+}
+
+// geometryToWKT formats a Polygon geometry with polygonToWKT and a
+// MultiPolygon as a WKT MULTIPOLYGON.
+func geometryToWKT(g Geometry) string {
+	if g.Type != "MultiPolygon" && len(g.Polygons) == 1 {
+		return polygonToWKT(g.Polygons[0])
+	}
+	var b strings.Builder
+	b.WriteString("MULTIPOLYGON(")
+	for i, polygon := range g.Polygons {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		writeWKTRings(&b, polygon)
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+func writeWKTRings(b *strings.Builder, p Polygon) {
+	b.WriteString("(")
+	for r, ring := range p {
+		if r > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString("(")
+		for i, point := range ring {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(strconv.FormatFloat(wrapLng(point[0]), 'f', -1, 64))
+			b.WriteString(" ")
+			b.WriteString(strconv.FormatFloat(point[1], 'f', -1, 64))
+		}
+		b.WriteString(")")
+	}
+	b.WriteString(")")
+}
+
+// parseWKTPolygon parses a WKT POLYGON as written by polygonToWKT. Like
+// parseWKTPoint it reads positions as lng lat and ignores whitespace.
+func parseWKTPolygon(text string) (Polygon, error) {
+	text = strings.TrimSpace(text)
+	if len(text) < len("POLYGON") || !strings.EqualFold(text[:len("POLYGON")], "POLYGON") {
+		return nil, fmt.Errorf("expected POLYGON")
+	}
+	body := strings.TrimSpace(text[len("POLYGON"):])
+	if !strings.HasPrefix(body, "(") || !strings.HasSuffix(body, ")") {
+		return nil, fmt.Errorf("expected POLYGON((lng lat, ...))")
+	}
+	body = strings.TrimSpace(body[1 : len(body)-1])
+
+	var polygon Polygon
+	for body != "" {
+		if !strings.HasPrefix(body, "(") {
+			return nil, fmt.Errorf("ring %d: expected (", len(polygon))
+		}
+		end := strings.Index(body, ")")
+		if end < 0 {
+			return nil, fmt.Errorf("ring %d: missing )", len(polygon))
+		}
+		var ring [][]float64
+		for _, position := range strings.Split(body[1:end], ",") {
+			fields := strings.Fields(position)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("ring %d: expected 2 coordinates, got %d", len(polygon), len(fields))
+			}
+			lng, err := strconv.ParseFloat(fields[0], 64)
+			if err != nil {
+				return nil, fmt.Errorf("ring %d: invalid longitude %q", len(polygon), fields[0])
+			}
+			lat, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("ring %d: invalid latitude %q", len(polygon), fields[1])
+			}
+			ring = append(ring, []float64{lng, lat})
+		}
+		polygon = append(polygon, ring)
+
+		body = strings.TrimSpace(body[end+1:])
+		if rest, ok := strings.CutPrefix(body, ","); ok {
+			body = strings.TrimSpace(rest)
+			if body == "" {
+				return nil, fmt.Errorf("trailing comma")
+			}
+		} else if body != "" {
+			return nil, fmt.Errorf("expected , between rings")
+		}
+	}
+	if len(polygon) == 0 {
+		return nil, fmt.Errorf("no rings")
+	}
+	return polygon, nil
+}
+
+// parseWKTPoint parses a Well-Known Text point such as "POINT(41.86 9.59)".
+// WKT gives x then y, so the first number is the longitude and the second
+// the latitude, the reverse of the lat,lng order used by query parameters.
+// The keyword is case-insensitive and whitespace around the tokens is
+// ignored; other geometry types, Z/M coordinates and POINT EMPTY are errors.
+func parseWKTPoint(text string) (lng float64, lat float64, err error) {
+	text = strings.TrimSpace(text)
+	if len(text) < len("POINT") || !strings.EqualFold(text[:len("POINT")], "POINT") {
+		return 0, 0, fmt.Errorf("expected POINT")
+	}
+	body := strings.TrimSpace(text[len("POINT"):])
+	if !strings.HasPrefix(body, "(") || !strings.HasSuffix(body, ")") {
+		return 0, 0, fmt.Errorf("expected POINT(lng lat)")
+	}
+	fields := strings.Fields(body[1 : len(body)-1])
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("expected 2 coordinates, got %d", len(fields))
+	}
+	if lng, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid longitude %q", fields[0])
+	}
+	if lat, err = strconv.ParseFloat(fields[1], 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid latitude %q", fields[1])
+	}
+	return lng, lat, nil
+}